@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"greenlight/proj/internal/api/tasks"
 	"greenlight/proj/internal/config"
 	"greenlight/proj/internal/services"
@@ -8,6 +9,7 @@ import (
 	"io"
 	"log/slog"
 	"testing"
+	"time"
 
 	govalidator "github.com/go-playground/validator/v10"
 	"github.com/gorilla/schema"
@@ -63,3 +65,81 @@ func NewTestApplication(cfg *config.Config, t *testing.T) *Application {
 	}
 	return app
 }
+
+// reloadContentFilter periodically reloads content filter rules if the rules file has changed, until ctx is done.
+// Invalid rules are logged and the previous ones stay in use. Reload is disabled if the interval isn't positive
+func (app *Application) reloadContentFilter(ctx context.Context) {
+	if app.cfg.ContentFilter.ReloadInterval <= 0 {
+		app.log.Info("Content filter rules reload is disabled")
+		return
+	}
+	ticker := time.NewTicker(app.cfg.ContentFilter.ReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := app.Services.ContentFilter.ReloadIfChanged()
+			if err != nil {
+				app.log.Error("Failed to reload content filter rules", "err", err)
+				continue
+			}
+			if reloaded {
+				app.log.Info("Content filter rules reloaded")
+			}
+		}
+	}
+}
+
+// purgeExpiredTokens periodically removes revocations of the tokens which have expired anyway and refresh tokens
+// which can't be used anymore, until ctx is done. Purge is disabled if the interval isn't positive
+func (app *Application) purgeExpiredTokens(ctx context.Context) {
+	if app.cfg.Tokens.RevocationsPurgeInterval <= 0 {
+		app.log.Info("Expired tokens purge is disabled")
+		return
+	}
+	ticker := time.NewTicker(app.cfg.Tokens.RevocationsPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := app.Services.Auth.PurgeExpiredRevocations(); err != nil {
+				app.log.Error("Failed to purge expired revocations", "err", err)
+			}
+			if _, err := app.Services.Auth.PurgeExpiredRefreshTokens(); err != nil {
+				app.log.Error("Failed to purge expired refresh tokens", "err", err)
+			}
+		}
+	}
+}
+
+// purgeTrash periodically removes movies, which have been in the trash for longer than retention period,
+// until ctx is done. Purge is disabled if the interval isn't positive
+func (app *Application) purgeTrash(ctx context.Context) {
+	if app.cfg.Movies.TrashPurgeInterval <= 0 {
+		app.log.Info("Trash purge is disabled")
+		return
+	}
+	ticker := time.NewTicker(app.cfg.Movies.TrashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := app.Services.Movies.PurgeTrash(app.cfg.Movies.TrashRetention); err != nil {
+				app.log.Error("Failed to purge trash", "err", err)
+			}
+		}
+	}
+}
+
+// syncRoles applies changes of the role definitions to the users. Failed sync is retried on the next start
+func (app *Application) syncRoles(ctx context.Context) {
+	if err := app.Services.Auth.SyncRoles(ctx); err != nil {
+		app.log.Error("Failed to sync roles", "err", err)
+	}
+}
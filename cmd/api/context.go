@@ -2,4 +2,8 @@ package main
 
 type CtxKey string
 
-const CtxKeyUser CtxKey = "user"
+const (
+	CtxKeyUser        CtxKey = "user"
+	CtxKeyAccessToken CtxKey = "access_token" // *models.AccessToken, set for requests authenticated with bearer tokens
+	CtxKeyApiKey      CtxKey = "api_key"      // *models.ApiKey, set for requests authenticated with API keys
+)
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"greenlight/proj/internal/domain/fields"
+	"greenlight/proj/internal/domain/filters"
 	"greenlight/proj/internal/domain/models"
 	"greenlight/proj/internal/lib/validator"
+	"greenlight/proj/internal/services/apikeys"
 	"greenlight/proj/internal/services/auth"
 	"greenlight/proj/internal/services/movies"
+	"greenlight/proj/internal/services/replies"
 	"greenlight/proj/internal/services/reviews"
+	"io"
+	"maps"
 	"math"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/render"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc/status"
@@ -31,7 +41,22 @@ func (app *Application) getMovie(w http.ResponseWriter, r *http.Request) {
 	if !extracted {
 		return
 	}
-	movie, err := app.Services.Movies.Get(id)
+	var params movieProjectionParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	projection, validationErrs := params.projection()
+	if len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	movie, err := app.Services.Movies.Get(id, projection)
 	if err != nil {
 		switch {
 		case errors.Is(err, movies.ErrMovieNotFound):
@@ -41,17 +66,71 @@ func (app *Application) getMovie(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	app.Http.Ok(w, r, envelop{"movie": movie}, "")
+	projected, err := projectMovie(movie, projection)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	etag, err := movieETag(movie.ID, movie.Version, projected)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" && etagMatchesAny(ifNoneMatch, etag) {
+		app.Http.NotModified(w, r, etag)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	app.Http.Ok(w, r, envelop{"movie": projected}, "")
+}
+
+// movieFiltersParams are query params shared by the endpoints listing movies
+type movieFiltersParams struct {
+	Title        string     `validate:"omitempty,max=255"`
+	Genres       []string   `validate:"omitempty,min=1,max=5,unique" schema:"genres"`
+	Year         *int32     `validate:"omitempty,min=1888,max=2100" schema:"year"`
+	YearGt       *int32     `validate:"omitempty,min=1888,max=2100" schema:"year_gt"`
+	YearGte      *int32     `validate:"omitempty,min=1888,max=2100" schema:"year_gte"`
+	YearLt       *int32     `validate:"omitempty,min=1888,max=2100" schema:"year_lt"`
+	YearLte      *int32     `validate:"omitempty,min=1888,max=2100" schema:"year_lte"`
+	RuntimeGt    *int32     `validate:"omitempty,min=0" schema:"runtime_gt"`
+	RuntimeGte   *int32     `validate:"omitempty,min=0" schema:"runtime_gte"`
+	RuntimeLt    *int32     `validate:"omitempty,min=0" schema:"runtime_lt"`
+	RuntimeLte   *int32     `validate:"omitempty,min=0" schema:"runtime_lte"`
+	CreatedAtGt  *time.Time `schema:"created_at_gt"`
+	CreatedAtGte *time.Time `schema:"created_at_gte"`
+	CreatedAtLt  *time.Time `schema:"created_at_lt"`
+	CreatedAtLte *time.Time `schema:"created_at_lte"`
+	RatingGt     *float64   `validate:"omitempty,min=1,max=5" schema:"rating_gt"`
+	RatingGte    *float64   `validate:"omitempty,min=1,max=5" schema:"rating_gte"`
+	RatingLt     *float64   `validate:"omitempty,min=1,max=5" schema:"rating_lt"`
+	RatingLte    *float64   `validate:"omitempty,min=1,max=5" schema:"rating_lte"`
+}
+
+// movieFilters converts params into typed movie filters. Returns errors for inconsistent range bounds
+func (p *movieFiltersParams) movieFilters() (filters.MovieFilters, map[string]string) {
+	genres := p.Genres
+	if genres == nil {
+		genres = []string{}
+	}
+	compareTime := func(a, b time.Time) int { return a.Compare(b) }
+	return filters.NewMovieFilters(
+		p.Title, genres,
+		filters.NewRange("year", cmp.Compare[int32], p.Year, p.YearGt, p.YearGte, p.YearLt, p.YearLte),
+		filters.NewRange("runtime", cmp.Compare[int32], nil, p.RuntimeGt, p.RuntimeGte, p.RuntimeLt, p.RuntimeLte),
+		filters.NewRange("created_at", compareTime, nil, p.CreatedAtGt, p.CreatedAtGte, p.CreatedAtLt, p.CreatedAtLte),
+		filters.NewRange("rating", cmp.Compare[float64], nil, p.RatingGt, p.RatingGte, p.RatingLt, p.RatingLte),
+	)
 }
 
 func (app *Application) getMovies(w http.ResponseWriter, r *http.Request) {
 	type queryParams struct {
-		Sort     string   `validate:"omitempty,sortbymoviefield" schema:"sort,default:-id"`
-		PageSize int      `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
-		Page     int      `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
-		Title    string   `validate:"omitempty,max=255"`
-		Year     int32    `validate:"omitempty,min=1888,max=2100"`
-		Genres   []string `validate:"omitempty,min=1,max=5,unique" schema:"genres"`
+		movieFiltersParams
+		movieProjectionParams
+		Sort     string `validate:"omitempty,sortbymoviefield" schema:"sort,default:-id"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+		Cursor   string `validate:"omitempty,max=2048" schema:"cursor"`
 	}
 	app.validator.RegisterValidation("sortbymoviefield", validator.ValidateSortByMovieField)
 	var params queryParams
@@ -65,12 +144,21 @@ func (app *Application) getMovies(w http.ResponseWriter, r *http.Request) {
 		app.Http.UnprocessableEntity(w, r, validationErrs)
 		return
 	}
-	if params.Genres == nil {
-		params.Genres = []string{}
+	movieFilters, validationErrs := params.movieFilters()
+	projection, projectionErrs := params.projection()
+	maps.Copy(validationErrs, projectionErrs)
+	if len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	// cursor mode is opt-in, the first page is requested with an empty cursor (?cursor=)
+	if qs.Has("cursor") {
+		app.getMoviesByCursor(w, r, movieFilters, projection, params.Cursor, params.PageSize, params.Sort)
+		return
 	}
 	movies, totalRecords, err := app.Services.Movies.List(
-		params.Title,
-		params.Genres,
+		movieFilters,
+		projection,
 		params.Page,
 		params.PageSize,
 		params.Sort,
@@ -79,6 +167,11 @@ func (app *Application) getMovies(w http.ResponseWriter, r *http.Request) {
 		app.Http.ServerError(w, r, err, "")
 		return
 	}
+	projected, err := projectMovies(movies, projection)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
 	app.Http.Ok(
 		w, r,
 		envelop{
@@ -88,23 +181,166 @@ func (app *Application) getMovies(w http.ResponseWriter, r *http.Request) {
 			"total_records": totalRecords,
 			"first_page":    1,
 			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
-			"movies":        movies,
+			"movies":        projected,
 		}, "",
 	)
 }
 
-func (app *Application) createMovie(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Title   string              `validate:"required,max=255"`
-		Year    int32               `validate:"required,min=1888,max=2100"`
-		Runtime fields.MovieRuntime `validate:"required,gt=0"`
-		Genres  []string            `validate:"required,min=1,max=5,unique"`
+func (app *Application) searchMovies(w http.ResponseWriter, r *http.Request) {
+	type queryParams struct {
+		movieFiltersParams
+		movieProjectionParams
+		Q        string `validate:"required,max=255" schema:"q"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
 	}
-	var req request
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	movieFilters, validationErrs := params.movieFilters()
+	projection, projectionErrs := params.projection()
+	maps.Copy(validationErrs, projectionErrs)
+	if len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	hits, totalRecords, err := app.Services.Movies.Search(params.Q, movieFilters, projection, params.Page, params.PageSize)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	projected, err := projectSearchHits(hits, projection)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(hits),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"movies":        projected,
+		}, "",
+	)
+}
+
+func (app *Application) getMoviesByCursor(
+	w http.ResponseWriter, r *http.Request, movieFilters filters.MovieFilters, projection filters.Projection,
+	cursor string, pageSize int, sort string,
+) {
+	moviesPage, nextCursor, prevCursor, err := app.Services.Movies.ListByCursor(movieFilters, projection, cursor, pageSize, sort)
+	if err != nil {
+		switch {
+		case errors.Is(err, movies.ErrInvalidCursor):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"cursor": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	projected, err := projectMovies(moviesPage, projection)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(moviesPage),
+			"page_size":     pageSize,
+			"next_cursor":   nullIfEmpty(nextCursor),
+			"prev_cursor":   nullIfEmpty(prevCursor),
+			"movies":        projected,
+		}, "",
+	)
+}
+
+func (app *Application) exportMovies(w http.ResponseWriter, r *http.Request) {
+	type queryParams struct {
+		movieFiltersParams
+		Sort           string `validate:"omitempty,sortbymoviefield" schema:"sort,default:id"`
+		Format         string `validate:"omitempty,oneof=csv ndjson json" schema:"format"`
+		IncludeReviews bool   `schema:"include_reviews"`
+	}
+	app.validator.RegisterValidation("sortbymoviefield", validator.ValidateSortByMovieField)
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	movieFilters, validationErrs := params.movieFilters()
+	if len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	format := params.Format
+	if format == "" {
+		format = negotiateExportFormat(r.Header.Get("Accept"))
+		if format == "" {
+			app.Http.NotAcceptable(w, r, "Supported formats are text/csv, application/x-ndjson and application/json")
+			return
+		}
+	}
+	// export of the whole catalog can't fit into server write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		app.log.Warn("Unable to reset write deadline for export", "err", err)
+	}
+	var exportWriter movieExportWriter
+	err := app.Services.Movies.Export(r.Context(), movieFilters, params.Sort, params.IncludeReviews, func(movie *models.Movie) error {
+		// response is started lazily, so errors occurred before the first row still can be reported properly
+		if exportWriter == nil {
+			exportWriter = newMovieExportWriter(w, format, params.IncludeReviews)
+		}
+		return exportWriter.Write(movie)
+	})
+	if err != nil {
+		if exportWriter == nil {
+			app.Http.ServerError(w, r, err, "")
+			return
+		}
+		// status is already sent, so the only way to signal failure is to abort the connection
+		app.log.Error("Export interrupted", "err", err)
+		panic(http.ErrAbortHandler)
+	}
+	if exportWriter == nil {
+		exportWriter = newMovieExportWriter(w, format, params.IncludeReviews)
+	}
+	if err := exportWriter.Close(); err != nil {
+		app.log.Error("Error finishing export", "err", err)
+	}
+}
+
+// movieInput is a movie submitted for creation, either directly or as a row of import
+type movieInput struct {
+	Title   string              `validate:"required,max=255"`
+	Year    int32               `validate:"required,min=1888,max=2100"`
+	Runtime fields.MovieRuntime `validate:"required,gt=0"`
+	Genres  []string            `validate:"required,min=1,max=5,unique"`
+}
+
+func (app *Application) createMovie(w http.ResponseWriter, r *http.Request) {
+	var req movieInput
 	if !app.readReqBodyAndValidate(w, r, &req) {
 		return
 	}
-	createdMovie, err := app.Services.Movies.Create(req.Title, req.Year, req.Runtime, req.Genres)
+	userID := app.Http.ContextGetUser(r).ID
+	createdMovie, err := app.Services.Movies.Create(req.Title, req.Year, req.Runtime, req.Genres, userID)
 	if err != nil {
 		if errors.Is(err, movies.ErrMovieAlreadyExists) {
 			app.Http.Conflict(w, r, err.Error())
@@ -117,6 +353,109 @@ func (app *Application) createMovie(w http.ResponseWriter, r *http.Request) {
 	app.Http.Created(w, r, envelop{"movie": createdMovie}, "Movie successfully created")
 }
 
+func (app *Application) importMovies(w http.ResponseWriter, r *http.Request) {
+	type queryParams struct {
+		DryRun bool `schema:"dry_run"`
+	}
+	type rowReport struct {
+		Row    int               `json:"row"`
+		Status string            `json:"status"`
+		ID     int64             `json:"id,omitempty"`
+		Errors map[string]string `json:"errors,omitempty"`
+	}
+	const (
+		statusCreated   = "created"
+		statusValid     = "valid"
+		statusDuplicate = "duplicate"
+		statusInvalid   = "invalid"
+	)
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	rowsReader, err := newMovieRowsReader(r.Header.Get("Content-Type"), http.MaxBytesReader(w, r.Body, maxImportBodyBytes))
+	if err != nil {
+		if errors.Is(err, errUnsupportedImportFormat) {
+			app.Http.UnsupportedMediaType(w, r, err.Error())
+			return
+		}
+		app.Http.BadRequest(w, r, err.Error())
+		return
+	}
+	userID := app.Http.ContextGetUser(r).ID
+	report := make([]rowReport, 0)
+	counts := make(map[string]int)
+	// the whole file is read before saving anything, so the import is applied all at once
+	// and duplicates are detected across all of its rows
+	toCreate := make([]*models.Movie, 0)
+	toCreateReportIdx := make([]int, 0)
+	for {
+		row, input, rowErrs, err := rowsReader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			app.Http.BadRequest(w, r, fmt.Sprintf("Unable to read row %d: %s", row, err.Error()))
+			return
+		}
+		if input != nil {
+			// parse errors take precedence over validation ones, as they describe the actual problem of the field
+			validationErrs := validator.ValidateStruct(app.validator, input)
+			for field, msg := range rowErrs {
+				if validationErrs == nil {
+					validationErrs = make(map[string]string)
+				}
+				validationErrs[field] = msg
+			}
+			rowErrs = validationErrs
+		}
+		if len(rowErrs) > 0 {
+			report = append(report, rowReport{Row: row, Status: statusInvalid, Errors: rowErrs})
+			counts[statusInvalid]++
+			continue
+		}
+		report = append(report, rowReport{Row: row})
+		toCreate = append(toCreate, &models.Movie{
+			Title: input.Title, Year: input.Year, Runtime: input.Runtime, Genres: input.Genres, UserID: userID,
+		})
+		toCreateReportIdx = append(toCreateReportIdx, len(report)-1)
+	}
+	if len(toCreate) > 0 {
+		errs, err := app.Services.Movies.CreateMany(toCreate, params.DryRun)
+		if err != nil {
+			app.Http.ServerError(w, r, err, "")
+			return
+		}
+		for i, err := range errs {
+			rowReport := &report[toCreateReportIdx[i]]
+			switch {
+			case errors.Is(err, movies.ErrMovieAlreadyExists):
+				rowReport.Status = statusDuplicate
+			case params.DryRun:
+				rowReport.Status = statusValid
+			default:
+				rowReport.Status = statusCreated
+				rowReport.ID = toCreate[i].ID
+			}
+			counts[rowReport.Status]++
+		}
+	}
+	msg := "Movies successfully imported"
+	if params.DryRun {
+		msg = "Dry run completed, no movies were saved"
+	}
+	app.Http.Ok(w, r, envelop{
+		"dry_run":    params.DryRun,
+		"total_rows": len(report),
+		"created":    counts[statusCreated],
+		"valid":      counts[statusValid],
+		"duplicates": counts[statusDuplicate],
+		"invalid":    counts[statusInvalid],
+		"rows":       report,
+	}, msg)
+}
+
 func (app *Application) updateMovie(w http.ResponseWriter, r *http.Request) {
 	id, extracted := app.Http.extractIDParam(w, r)
 	if !extracted {
@@ -132,11 +471,16 @@ func (app *Application) updateMovie(w http.ResponseWriter, r *http.Request) {
 	if !app.readReqBodyAndValidate(w, r, &req) {
 		return
 	}
-	updatedMovie, err := app.Services.Movies.Update(id, req.Title, req.Year, req.Runtime, req.Genres)
+	userID := app.Http.ContextGetUser(r).ID
+	updatedMovie, err := app.Services.Movies.Update(
+		id, userID, ifMatchVersion(r, id), req.Title, req.Year, req.Runtime, req.Genres,
+	)
 	if err != nil {
 		switch {
 		case errors.Is(err, movies.ErrMovieNotFound):
 			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, movies.ErrPreconditionFailed):
+			app.Http.PreconditionFailed(w, r, err.Error())
 		case errors.Is(err, movies.ErrNoArgumentsChanged):
 			app.Http.BadRequest(w, r, err.Error())
 		case errors.Is(err, movies.ErrMovieAlreadyExists) || errors.Is(err, movies.ErrEditConflict):
@@ -146,153 +490,1094 @@ func (app *Application) updateMovie(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
+	if !app.setMovieETag(w, r, updatedMovie) {
+		return
+	}
 	app.Http.Ok(w, r, envelop{"movie": updatedMovie}, "Movie successfully updated")
 }
 
-func (app *Application) deleteMovie(w http.ResponseWriter, r *http.Request) {
+func (app *Application) getMovieRevisions(w http.ResponseWriter, r *http.Request) {
 	id, extracted := app.Http.extractIDParam(w, r)
 	if !extracted {
 		return
 	}
-	err := app.Services.Movies.Delete(id)
+	revisions, err := app.Services.Movies.ListRevisions(id)
 	if err != nil {
-		if errors.Is(err, movies.ErrMovieNotFound) {
+		switch {
+		case errors.Is(err, movies.ErrMovieNotFound):
 			app.Http.NotFound(w, r, err.Error())
-		} else {
+		default:
 			app.Http.ServerError(w, r, err, "")
 		}
 		return
 	}
-	app.Http.NoContent(w, r, "Movie successfully deleted")
+	app.Http.Ok(w, r, envelop{"revisions": revisions}, "")
 }
 
-// AUTH
-
-func (app *Application) login(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Email    string `validate:"required,email"`
-		Password string `validate:"required,min=8"`
-	}
-	var req request
-	if !app.readReqBodyAndValidate(w, r, &req) {
-		return
-	}
-	tokens, err := app.Services.Auth.Login(r.Context(), req.Email, req.Password)
-	grpcErr, ok := status.FromError(err)
-	httpRespCode := runtime.HTTPStatusFromCode(grpcErr.Code())
-	if grpcErr.Message() != "" {
-		app.handleGRPCError(w, r, grpcErr, httpRespCode)
-		return
-	}
-	if ok {
-		app.Http.Response(w, r, envelop{"tokens": tokens}, "", httpRespCode)
+func (app *Application) getMovieRevision(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractIDParam(w, r)
+	if !extracted {
 		return
 	}
-	app.Http.ServerError(w, r, err, "")
-}
-
-func (app *Application) signup(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Username string `validate:"required,max=50,alphanum"`
-		Email    string `validate:"required,email"`
-		Password string `validate:"required,min=8"`
-	}
-	var req request
-	if !app.readReqBodyAndValidate(w, r, &req) {
+	version, extracted := app.Http.extractPositiveIntParam(w, r, "version")
+	if !extracted {
 		return
 	}
-	userID, err := app.Services.Auth.Signup(
-		r.Context(), req.Email, req.Username, req.Password, activationURL,
-	)
+	revision, err := app.Services.Movies.GetRevision(id, uint(version))
 	if err != nil {
-		grpcErr, ok := status.FromError(err)
-		if ok {
-			httpRespCode := runtime.HTTPStatusFromCode(grpcErr.Code())
-			if grpcErr.Message() != "" {
-				app.handleGRPCError(w, r, grpcErr, httpRespCode)
-			} else {
-				app.Http.ServerError(w, r, err, "")
-			}
-		} else {
+		switch {
+		case errors.Is(err, movies.ErrRevisionNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
 			app.Http.ServerError(w, r, err, "")
 		}
 		return
 	}
-	app.log.Debug("User created", "id", userID)
-	app.Http.Created(w, r, envelop{"id": userID}, "User successfully created. Please check your email for activation link to activate your account")
+	app.Http.Ok(w, r, envelop{"revision": revision}, "")
 }
 
-func (app *Application) getNewActivationToken(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		Email string `validate:"required,email"`
+func (app *Application) revertMovie(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractIDParam(w, r)
+	if !extracted {
+		return
 	}
-	var req request
-	if !app.readReqBodyAndValidate(w, r, &req) {
+	version, extracted := app.Http.extractPositiveIntParam(w, r, "version")
+	if !extracted {
 		return
 	}
-
-	err := app.Services.Auth.GetNewActivationToken(r.Context(), req.Email, activationURL)
+	userID := app.Http.ContextGetUser(r).ID
+	revertedMovie, err := app.Services.Movies.Revert(id, uint(version), userID, ifMatchVersion(r, id))
 	if err != nil {
 		switch {
-		case errors.Is(err, auth.ErrUserNotFound):
+		case errors.Is(err, movies.ErrMovieNotFound) || errors.Is(err, movies.ErrRevisionNotFound):
 			app.Http.NotFound(w, r, err.Error())
-		case errors.Is(err, auth.ErrInvalidData):
-			app.Http.BadRequest(w, r, err.Error())
+		case errors.Is(err, movies.ErrPreconditionFailed):
+			app.Http.PreconditionFailed(w, r, err.Error())
+		case errors.Is(err, movies.ErrNoArgumentsChanged):
+			app.Http.BadRequest(w, r, "Movie already has the same state as at the requested version")
+		case errors.Is(err, movies.ErrMovieAlreadyExists) || errors.Is(err, movies.ErrEditConflict):
+			app.Http.Conflict(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
 		}
-		app.Http.ServerError(w, r, err, "")
 		return
 	}
-	app.Http.NoContent(w, r, "New activation token sent to your email")
+	if !app.setMovieETag(w, r, revertedMovie) {
+		return
+	}
+	app.Http.Ok(w, r, envelop{"movie": revertedMovie}, "Movie successfully reverted")
 }
 
-func (app *Application) activateAccount(w http.ResponseWriter, r *http.Request) {
-	type request struct {
-		ActivationToken string `json:"token" validate:"required,min=26"`
-	}
-	var req request
-	if !app.readReqBodyAndValidate(w, r, &req) {
+func (app *Application) deleteMovie(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractIDParam(w, r)
+	if !extracted {
 		return
 	}
-	user, err := app.Services.Auth.ActivateUser(r.Context(), req.ActivationToken)
+	err := app.Services.Movies.Delete(id, ifMatchVersion(r, id))
 	if err != nil {
 		switch {
-		case errors.Is(err, auth.ErrUserNotFound):
+		case errors.Is(err, movies.ErrMovieNotFound):
 			app.Http.NotFound(w, r, err.Error())
-		case errors.Is(err, auth.ErrInvalidData):
-			app.Http.BadRequest(w, r, err.Error())
-		case errors.Is(err, auth.ErrUserAlreadyActivated):
-			app.Http.Conflict(w, r, err.Error())
+		case errors.Is(err, movies.ErrPreconditionFailed):
+			app.Http.PreconditionFailed(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
 		}
-		app.Http.ServerError(w, r, err, "")
 		return
 	}
-	app.Http.Created(w, r, envelop{"user": user}, "Account successfully activated")
+	app.Http.NoContent(w, r, "Movie successfully moved to the trash")
 }
 
-// reviews handlers
-
+func (app *Application) getTrashedMovies(w http.ResponseWriter, r *http.Request) {
+	type queryParams struct {
+		Sort     string `validate:"omitempty,sortbymoviefield" schema:"sort,default:-deleted_at"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+	}
+	app.validator.RegisterValidation("sortbymoviefield", validator.ValidateSortByMovieField)
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	movies, totalRecords, err := app.Services.Movies.ListTrash(params.Page, params.PageSize, params.Sort)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(movies),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"movies":        movies,
+		}, "",
+	)
+}
+
+func (app *Application) restoreMovie(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractIDParam(w, r)
+	if !extracted {
+		return
+	}
+	movie, err := app.Services.Movies.Restore(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, movies.ErrMovieNotFound):
+			app.Http.NotFound(w, r, "movie not found in the trash")
+		case errors.Is(err, movies.ErrMovieAlreadyExists):
+			app.Http.Conflict(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"movie": movie}, "Movie successfully restored")
+}
+
+// AUTH
+
+func (app *Application) login(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Email    string `validate:"required,email"`
+		Password string `validate:"required,min=8"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	tokens, err := app.Services.Auth.Login(r.Context(), req.Email, req.Password)
+	if errors.Is(err, auth.ErrAccountClosed) {
+		app.Http.Unauthorized(w, r, err.Error())
+		return
+	}
+	grpcErr, ok := status.FromError(err)
+	httpRespCode := runtime.HTTPStatusFromCode(grpcErr.Code())
+	if grpcErr.Message() != "" {
+		app.handleGRPCError(w, r, grpcErr, httpRespCode)
+		return
+	}
+	if ok {
+		app.Http.Response(w, r, envelop{"tokens": tokens}, "", httpRespCode)
+		return
+	}
+	app.Http.ServerError(w, r, err, "")
+}
+
+// logout revokes the access token of the request and, if the refresh token is provided, all the tokens of the login
+func (app *Application) logout(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	var req request
+	if r.ContentLength != 0 && !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	accessToken := app.Http.ContextGetAccessToken(r)
+	if accessToken == nil {
+		app.Http.Unauthorized(w, r, "you must be authenticated with the access token to log out")
+		return
+	}
+	if err := app.Services.Auth.Logout(r.Context(), *accessToken, req.RefreshToken); err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.NoContent(w, r, "Successfully logged out")
+}
+
+// revokeUserSessions revokes all the tokens and API keys issued to the user, so the user has to log in again
+func (app *Application) revokeUserSessions(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	if err := app.Services.Auth.RevokeUserSessions(r.Context(), int64(userID)); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.NoContent(w, r, "All sessions of the user are revoked")
+}
+
+// findUser looks the user up by email, so admins can find the ID of the user to manage
+func (app *Application) findUser(w http.ResponseWriter, r *http.Request) {
+	type queryParams struct {
+		Email string `validate:"required,email" schema:"email"`
+	}
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	user, err := app.Services.Auth.GetUser(r.Context(), auth.GetUserParams{Email: params.Email})
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"user": user}, "")
+}
+
+func (app *Application) getUserPermissions(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	permissions, err := app.Services.Auth.UserPermissions(r.Context(), int64(userID))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	role, err := app.Services.Auth.UserRole(r.Context(), int64(userID))
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(w, r, envelop{"role": role, "permissions": permissions}, "")
+}
+
+func (app *Application) changeUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Role string `validate:"required,max=100"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	err := app.Services.Auth.ChangeUserRole(r.Context(), int64(userID), req.Role, app.Http.ContextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, auth.ErrUnknownRole):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"role": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"role": req.Role}, "Role successfully changed")
+}
+
+func (app *Application) getRoles(w http.ResponseWriter, r *http.Request) {
+	roles := app.Services.Auth.RoleDefinitions()
+	app.Http.Ok(w, r, envelop{"default": roles.Default, "roles": roles.Definitions}, "")
+}
+
+func (app *Application) grantUserPermissions(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Codes []string `validate:"required,min=1,max=50,dive,permissioncode"`
+	}
+	app.validator.RegisterValidation("permissioncode", validator.ValidatePermissionCode)
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	if err := app.Services.Auth.GrantPermissions(r.Context(), int64(userID), req.Codes); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, auth.ErrUnknownPermission):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"codes": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.NoContent(w, r, "Permissions successfully granted")
+}
+
+func (app *Application) revokeUserPermission(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	code := chi.URLParam(r, "code")
+	err := app.Services.Auth.RevokePermission(r.Context(), int64(userID), code, app.Http.ContextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound), errors.Is(err, auth.ErrUnknownPermission), errors.Is(err, auth.ErrPermissionNotGranted):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.NoContent(w, r, "Permission successfully revoked")
+}
+
+func (app *Application) getPermissions(w http.ResponseWriter, r *http.Request) {
+	permissions, err := app.Services.Auth.ListPermissions(r.Context())
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(w, r, envelop{"permissions": permissions}, "")
+}
+
+func (app *Application) createPermission(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Code string `validate:"required,max=100,permissioncode"`
+	}
+	app.validator.RegisterValidation("permissioncode", validator.ValidatePermissionCode)
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	if err := app.Services.Auth.CreatePermission(r.Context(), req.Code); err != nil {
+		switch {
+		case errors.Is(err, auth.ErrPermissionExists):
+			app.Http.Conflict(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Created(w, r, envelop{"code": req.Code}, "Permission successfully created")
+}
+
+// refreshTokens exchanges the refresh token for the new pair of tokens. The refresh token can be used only once
+func (app *Application) refreshTokens(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	tokens, err := app.Services.Auth.RefreshTokens(r.Context(), req.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidRefreshToken), errors.Is(err, auth.ErrRefreshTokenReused):
+			app.Http.Unauthorized(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"tokens": tokens}, "")
+}
+
+func (app *Application) signup(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Username string `validate:"required,max=50,alphanum"`
+		Email    string `validate:"required,email"`
+		Password string `validate:"required,min=8"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	userID, err := app.Services.Auth.Signup(
+		r.Context(), req.Email, req.Username, req.Password, activationURL,
+	)
+	if err != nil {
+		grpcErr, ok := status.FromError(err)
+		if ok {
+			httpRespCode := runtime.HTTPStatusFromCode(grpcErr.Code())
+			if grpcErr.Message() != "" {
+				app.handleGRPCError(w, r, grpcErr, httpRespCode)
+			} else {
+				app.Http.ServerError(w, r, err, "")
+			}
+		} else {
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.log.Debug("User created", "id", userID)
+	app.Http.Created(w, r, envelop{"id": userID}, "User successfully created. Please check your email for activation link to activate your account")
+}
+
+func (app *Application) getNewActivationToken(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Email string `validate:"required,email"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+
+	err := app.Services.Auth.GetNewActivationToken(r.Context(), req.Email, activationURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, auth.ErrInvalidData):
+			app.Http.BadRequest(w, r, err.Error())
+		}
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.NoContent(w, r, "New activation token sent to your email")
+}
+
+func (app *Application) activateAccount(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		ActivationToken string `json:"token" validate:"required,min=26"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	user, err := app.Services.Auth.ActivateUser(r.Context(), req.ActivationToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrUserNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, auth.ErrInvalidData):
+			app.Http.BadRequest(w, r, err.Error())
+		case errors.Is(err, auth.ErrUserAlreadyActivated):
+			app.Http.Conflict(w, r, err.Error())
+		}
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Created(w, r, envelop{"user": user}, "Account successfully activated")
+}
+
+// reviews handlers
+
 func (app *Application) addReviewForMovie(w http.ResponseWriter, r *http.Request) {
 	movieID, extracted := app.Http.extractIDParam(w, r)
 	if !extracted {
 		return
 	}
 	type request struct {
-		Rating  int32  `validate:"required,gt=0,lt=6"`
-		Comment string `validate:"omitempty,max=255"`
+		Rating  int32  `validate:"required,gt=0,lt=6"`
+		Comment string `validate:"omitempty,max=255"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	userID := r.Context().Value(CtxKeyUser).(*models.User).ID
+	review, err := app.Services.Reviews.Create(req.Rating, req.Comment, int64(movieID), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrReviewAlreadyExists):
+			app.Http.Conflict(w, r, "You have already reviewed this movie")
+		case errors.Is(err, reviews.ErrCommentRejected):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"comment": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Created(w, r, envelop{"review": review}, "Review successfully created and awaits moderation")
+}
+
+func (app *Application) getMovieReviews(w http.ResponseWriter, r *http.Request) {
+	movieID, extracted := app.Http.extractIDParam(w, r)
+	if !extracted {
+		return
+	}
+	type queryParams struct {
+		Sort     string `validate:"omitempty,sortbyreviewfield" schema:"sort,default:-created_at"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+	}
+	app.validator.RegisterValidation("sortbyreviewfield", validator.ValidateSortByReviewField)
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	movieReviews, totalRecords, err := app.Services.Reviews.List(int64(movieID), params.Page, params.PageSize, params.Sort)
+	if err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrMovieNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(movieReviews),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"reviews":       movieReviews,
+		}, "",
+	)
+}
+
+func (app *Application) updateReview(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Rating  *int32  `validate:"omitempty,gt=0,lt=6"`
+		Comment *string `validate:"omitempty,max=255"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	user := app.Http.ContextGetUser(r)
+	canModerate, err := app.hasPermission(r, "reviews:moderate")
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	review, err := app.Services.Reviews.Update(int64(id), user.ID, canModerate, req.Rating, req.Comment)
+	if err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, reviews.ErrNotReviewAuthor):
+			app.Http.Forbidden(w, r, err.Error())
+		case errors.Is(err, reviews.ErrNoArgumentsChanged):
+			app.Http.BadRequest(w, r, err.Error())
+		case errors.Is(err, reviews.ErrCommentRejected):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"comment": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"review": review}, "Review successfully updated")
+}
+
+func (app *Application) deleteReview(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	user := app.Http.ContextGetUser(r)
+	canModerate, err := app.hasPermission(r, "reviews:moderate")
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	if err := app.Services.Reviews.Delete(int64(id), user.ID, canModerate); err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, reviews.ErrNotReviewAuthor):
+			app.Http.Forbidden(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.NoContent(w, r, "Review successfully deleted")
+}
+
+func (app *Application) flagReview(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Reason string `validate:"required,max=255"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	flag, err := app.Services.Reviews.Flag(int64(id), app.Http.ContextGetUser(r).ID, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, reviews.ErrAlreadyFlagged):
+			app.Http.Conflict(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Created(w, r, envelop{"flag": flag}, "Review has been sent to moderators")
+}
+
+func (app *Application) getModerationQueue(w http.ResponseWriter, r *http.Request) {
+	type queryParams struct {
+		Status   string `validate:"omitempty,max=64" schema:"status"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+	}
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	statuses := splitList(params.Status)
+	if len(statuses) == 0 {
+		statuses = []string{models.ReviewPending, models.ReviewFlagged}
+	}
+	knownStatuses := []string{models.ReviewPending, models.ReviewFlagged, models.ReviewApproved, models.ReviewRejected}
+	for _, status := range statuses {
+		if !slices.Contains(knownStatuses, status) {
+			app.Http.UnprocessableEntity(w, r, map[string]string{
+				"status": "Value should be one of " + strings.Join(knownStatuses, ", "),
+			})
+			return
+		}
+	}
+	queue, totalRecords, err := app.Services.Reviews.ModerationQueue(statuses, params.Page, params.PageSize)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(queue),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"reviews":       queue,
+		}, "",
+	)
+}
+
+func (app *Application) moderateReview(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Decision string  `validate:"required,oneof=approve reject"`
+		Reason   *string `validate:"required_if=Decision reject,omitempty,max=255"`
 	}
 	var req request
 	if !app.readReqBodyAndValidate(w, r, &req) {
 		return
 	}
-	userID := r.Context().Value(CtxKeyUser).(*models.User).ID
-	review, err := app.Services.Reviews.Create(req.Rating, req.Comment, int64(movieID), userID)
+	moderatorID := app.Http.ContextGetUser(r).ID
+	var review *models.Review
+	var err error
+	if req.Decision == "approve" {
+		review, err = app.Services.Reviews.Approve(int64(id), moderatorID, req.Reason)
+	} else {
+		review, err = app.Services.Reviews.Reject(int64(id), moderatorID, *req.Reason)
+	}
 	if err != nil {
-		if errors.Is(err, reviews.ErrReviewAlreadyExists) {
-			app.Http.Conflict(w, r, "You have already reviewed this movie")
+		switch {
+		case errors.Is(err, reviews.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"review": review}, "Review successfully moderated")
+}
+
+func (app *Application) voteReview(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Helpful *bool `validate:"required"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	review, err := app.Services.Reviews.Vote(int64(id), app.Http.ContextGetUser(r).ID, *req.Helpful)
+	if err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, reviews.ErrOwnReviewVote):
+			app.Http.Forbidden(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"review": review}, "Vote successfully saved")
+}
+
+func (app *Application) deleteReviewVote(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	review, err := app.Services.Reviews.DeleteVote(int64(id), app.Http.ContextGetUser(r).ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, reviews.ErrVoteNotFound) || errors.Is(err, reviews.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"review": review}, "Vote successfully deleted")
+}
+
+func (app *Application) addReviewReply(w http.ResponseWriter, r *http.Request) {
+	reviewID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Body     string `validate:"required,max=1000"`
+		ParentID *int64 `json:"parent_id" validate:"omitempty,gt=0"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	reply, err := app.Services.Replies.Create(int64(reviewID), req.ParentID, app.Http.ContextGetUser(r).ID, req.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, replies.ErrReviewNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, replies.ErrParentNotFound), errors.Is(err, replies.ErrTooDeep):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"parent_id": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Created(w, r, envelop{"reply": reply}, "Reply successfully created")
+}
+
+func (app *Application) getReviewReplies(w http.ResponseWriter, r *http.Request) {
+	reviewID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type queryParams struct {
+		ParentID *int64 `validate:"omitempty,gt=0" schema:"parent_id"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+	}
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	review, reviewReplies, totalRecords, err := app.Services.Replies.List(int64(reviewID), params.ParentID, params.Page, params.PageSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, replies.ErrReviewNotFound), errors.Is(err, replies.ErrParentNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"review":        review,
+			"total_on_page": len(reviewReplies),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"replies":       reviewReplies,
+		}, "",
+	)
+}
+
+func (app *Application) updateReply(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	type request struct {
+		Body string `validate:"required,max=1000"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	reply, err := app.Services.Replies.Update(int64(id), app.Http.ContextGetUser(r).ID, req.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, replies.ErrReplyNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, replies.ErrNotReplyAuthor):
+			app.Http.Forbidden(w, r, err.Error())
+		case errors.Is(err, replies.ErrNoArgumentsChanged):
+			app.Http.BadRequest(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Ok(w, r, envelop{"reply": reply}, "Reply successfully updated")
+}
+
+func (app *Application) deleteReply(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	user := app.Http.ContextGetUser(r)
+	canModerate, err := app.hasPermission(r, "reviews:moderate")
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	if err := app.Services.Replies.Delete(int64(id), user.ID, canModerate); err != nil {
+		switch {
+		case errors.Is(err, replies.ErrReplyNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		case errors.Is(err, replies.ErrCantDeleteReply):
+			app.Http.Forbidden(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.NoContent(w, r, "Reply successfully deleted")
+}
+
+func (app *Application) getMe(w http.ResponseWriter, r *http.Request) {
+	user := app.Http.ContextGetUser(r)
+	var permissions []string
+	if apiKey := app.Http.ContextGetApiKey(r); apiKey != nil {
+		permissions = make([]string, 0, len(apiKey.Scopes))
+		for _, scope := range apiKey.Scopes {
+			hasPermission, err := app.hasPermission(r, scope)
+			if err != nil {
+				app.Http.ServerError(w, r, err, "")
+				return
+			}
+			if hasPermission {
+				permissions = append(permissions, scope)
+			}
+		}
+	} else {
+		var err error
+		permissions, err = app.Services.Auth.UserPermissions(r.Context(), user.ID)
+		if err != nil {
+			app.Http.ServerError(w, r, err, "")
 			return
 		}
+	}
+	app.Http.Ok(w, r, envelop{"user": user, "permissions": permissions}, "")
+}
+
+func (app *Application) deleteMe(w http.ResponseWriter, r *http.Request) {
+	if err := app.Services.Auth.CloseAccount(r.Context(), app.Http.ContextGetUser(r).ID); err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.NoContent(w, r, "Account successfully closed")
+}
+
+func (app *Application) getMyReviews(w http.ResponseWriter, r *http.Request) {
+	app.listUserReviews(w, r, app.Http.ContextGetUser(r).ID, false)
+}
+
+func (app *Application) getUserReviews(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	app.listUserReviews(w, r, int64(userID), true)
+}
+
+// listUserReviews responds with the page of the user reviews. Reviews which aren't public are listed only if approvedOnly is false
+func (app *Application) listUserReviews(w http.ResponseWriter, r *http.Request, userID int64, approvedOnly bool) {
+	type queryParams struct {
+		Sort     string `validate:"omitempty,sortbyreviewfield" schema:"sort,default:-created_at"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+	}
+	app.validator.RegisterValidation("sortbyreviewfield", validator.ValidateSortByReviewField)
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	userReviews, totalRecords, err := app.Services.Activity.UserReviews(userID, approvedOnly, params.Page, params.PageSize, params.Sort)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(userReviews),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"reviews":       userReviews,
+		}, "",
+	)
+}
+
+func (app *Application) getMyMovies(w http.ResponseWriter, r *http.Request) {
+	app.listUserMovies(w, r, app.Http.ContextGetUser(r).ID)
+}
+
+func (app *Application) getUserMovies(w http.ResponseWriter, r *http.Request) {
+	userID, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	app.listUserMovies(w, r, int64(userID))
+}
+
+// listUserMovies responds with the page of movies added by the user
+func (app *Application) listUserMovies(w http.ResponseWriter, r *http.Request, userID int64) {
+	type queryParams struct {
+		Sort     string `validate:"omitempty,sortbymoviefield" schema:"sort,default:-id"`
+		PageSize int    `validate:"omitempty,min=1,max=100" schema:"page_size,default:20"`
+		Page     int    `validate:"omitempty,min=1,max=10000000" schema:"page,default:1"`
+	}
+	app.validator.RegisterValidation("sortbymoviefield", validator.ValidateSortByMovieField)
+	var params queryParams
+	if err := app.Decoder.Decode(&params, r.URL.Query()); err != nil {
+		app.log.Error("Error during decoding query params", "msg", err.Error())
+		app.Http.BadRequest(w, r, "Invalid query params provided. Ensure that all query params are valid")
+		return
+	}
+	if validationErrs := validator.ValidateStruct(app.validator, &params); len(validationErrs) > 0 {
+		app.Http.UnprocessableEntity(w, r, validationErrs)
+		return
+	}
+	userMovies, totalRecords, err := app.Services.Activity.UserMovies(userID, params.Page, params.PageSize, params.Sort)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	projected, err := projectMovies(userMovies, filters.Projection{})
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return
+	}
+	app.Http.Ok(
+		w, r,
+		envelop{
+			"total_on_page": len(userMovies),
+			"current_page":  params.Page,
+			"page_size":     params.PageSize,
+			"total_records": totalRecords,
+			"first_page":    1,
+			"last_page":     math.Ceil(float64(totalRecords) / float64(params.PageSize)),
+			"movies":        projected,
+		}, "",
+	)
+}
+
+func (app *Application) createApiKey(w http.ResponseWriter, r *http.Request) {
+	type request struct {
+		Name      string     `validate:"required,max=100"`
+		Scopes    []string   `validate:"required,min=1,max=50,dive,required,max=100"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	var req request
+	if !app.readReqBodyAndValidate(w, r, &req) {
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		app.Http.UnprocessableEntity(w, r, map[string]string{"expires_at": "must be in the future"})
+		return
+	}
+	apiKey, plainKey, err := app.Services.ApiKeys.Create(r.Context(), app.Http.ContextGetUser(r).ID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikeys.ErrDuplicateName):
+			app.Http.Conflict(w, r, err.Error())
+		case errors.Is(err, apikeys.ErrScopeNotGranted):
+			app.Http.UnprocessableEntity(w, r, map[string]string{"scopes": err.Error()})
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.Created(w, r, envelop{"api_key": apiKey, "key": plainKey}, "Api key successfully created, store the key now, it won't be shown again")
+}
+
+func (app *Application) getApiKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.Services.ApiKeys.List(r.Context(), app.Http.ContextGetUser(r).ID)
+	if err != nil {
 		app.Http.ServerError(w, r, err, "")
 		return
 	}
-	app.Http.Created(w, r, envelop{"review": review}, "Review successfully created")
+	app.Http.Ok(w, r, envelop{"api_keys": keys}, "")
+}
+
+func (app *Application) revokeApiKey(w http.ResponseWriter, r *http.Request) {
+	id, extracted := app.Http.extractPositiveIntParam(w, r, "id")
+	if !extracted {
+		return
+	}
+	if err := app.Services.ApiKeys.Revoke(r.Context(), int64(id), app.Http.ContextGetUser(r).ID); err != nil {
+		switch {
+		case errors.Is(err, apikeys.ErrApiKeyNotFound):
+			app.Http.NotFound(w, r, err.Error())
+		default:
+			app.Http.ServerError(w, r, err, "")
+		}
+		return
+	}
+	app.Http.NoContent(w, r, "Api key successfully revoked")
 }
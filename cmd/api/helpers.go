@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"google.golang.org/grpc/status"
+	"greenlight/proj/internal/domain/fields"
+	"greenlight/proj/internal/domain/filters"
+	"greenlight/proj/internal/domain/models"
 	"greenlight/proj/internal/lib/validator"
 	"io"
+	"mime"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
 func (app *Application) readReqBodyAndValidate(w http.ResponseWriter, r *http.Request, dst any) (success bool) {
@@ -82,3 +91,370 @@ func parseJsonErr(err error) error {
 		return err
 	}
 }
+
+// nullIfEmpty converts empty string to nil, so it's rendered as null in JSON
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// splitList splits comma separated query param value, skipping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// movieProjectionParams are query params narrowing movie fields in responses and opting into embedded data
+type movieProjectionParams struct {
+	Fields  string `validate:"omitempty,max=255" schema:"fields"`
+	Include string `validate:"omitempty,max=255" schema:"include"`
+}
+
+func (p *movieProjectionParams) projection() (filters.Projection, map[string]string) {
+	return filters.NewProjection(splitList(p.Fields), splitList(p.Include))
+}
+
+// projectMovie renders the movie with the projection fields only.
+// Embedded data is rendered only if the projection includes it
+func projectMovie(movie *models.Movie, projection filters.Projection) (map[string]json.RawMessage, error) {
+	b, err := json.Marshal(movie)
+	if err != nil {
+		return nil, err
+	}
+	var projected map[string]json.RawMessage
+	if err := json.Unmarshal(b, &projected); err != nil {
+		return nil, err
+	}
+	for key := range projected {
+		switch key {
+		case filters.IncludeReviews, filters.IncludeRatingSummary:
+			if !projection.Includes(key) {
+				delete(projected, key)
+			}
+		default:
+			if !projection.HasField(key) {
+				delete(projected, key)
+			}
+		}
+	}
+	return projected, nil
+}
+
+// setMovieETag sets the tag of the default representation of the movie, the one GET returns without params.
+// The error response is sent if the tag can't be built
+func (app *Application) setMovieETag(w http.ResponseWriter, r *http.Request, movie *models.Movie) bool {
+	projected, err := projectMovie(movie, filters.Projection{})
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return false
+	}
+	etag, err := movieETag(movie.ID, movie.Version, projected)
+	if err != nil {
+		app.Http.ServerError(w, r, err, "")
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	return true
+}
+
+func projectMovies(movies []models.Movie, projection filters.Projection) ([]map[string]json.RawMessage, error) {
+	projected := make([]map[string]json.RawMessage, 0, len(movies))
+	for i := range movies {
+		movie, err := projectMovie(&movies[i], projection)
+		if err != nil {
+			return nil, err
+		}
+		projected = append(projected, movie)
+	}
+	return projected, nil
+}
+
+// projectSearchHits renders found movies with the projection fields, adding their relevance and highlighted title
+func projectSearchHits(hits []models.MovieSearchHit, projection filters.Projection) ([]map[string]json.RawMessage, error) {
+	projected := make([]map[string]json.RawMessage, 0, len(hits))
+	for i := range hits {
+		movie, err := projectMovie(&hits[i].Movie, projection)
+		if err != nil {
+			return nil, err
+		}
+		search, err := json.Marshal(map[string]any{
+			"rank":       hits[i].Rank,
+			"similarity": hits[i].Similarity,
+			"highlight":  hits[i].Highlight,
+		})
+		if err != nil {
+			return nil, err
+		}
+		movie["search"] = search
+		projected = append(projected, movie)
+	}
+	return projected, nil
+}
+
+const maxImportBodyBytes = 100 << 20 // 100MB
+
+var errUnsupportedImportFormat = errors.New("unsupported import format, use text/csv or application/x-ndjson content type")
+
+// movieRowsReader streams movies for import from the request body row by row
+type movieRowsReader interface {
+	// Read returns the next row number and the movie parsed from it.
+	// Row specific errors (e.g. invalid runtime format) are returned in rowErrs, so the import can go on,
+	// while err is returned only if the stream itself is broken. io.EOF is returned when there are no rows left
+	Read() (row int, input *movieInput, rowErrs map[string]string, err error)
+}
+
+func newMovieRowsReader(contentType string, body io.Reader) (movieRowsReader, error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return newCSVMovieRowsReader(body)
+	case "application/x-ndjson", "application/ndjson":
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1_048_576)
+		return &ndjsonMovieRowsReader{scanner: scanner}, nil
+	default:
+		return nil, errUnsupportedImportFormat
+	}
+}
+
+// csvMovieRowsReader reads csv with a header containing title, year, runtime and genres columns.
+// Genres are separated by comma inside the cell (e.g. "drama,comedy")
+type csvMovieRowsReader struct {
+	reader  *csv.Reader
+	columns map[string]int
+	row     int
+}
+
+func newCSVMovieRowsReader(body io.Reader) (*csvMovieRowsReader, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.ToLower(strings.TrimSpace(column))] = i
+	}
+	for _, column := range []string{"title", "year", "runtime", "genres"} {
+		if _, ok := columns[column]; !ok {
+			return nil, fmt.Errorf("csv header must contain %q column", column)
+		}
+	}
+	return &csvMovieRowsReader{reader: reader, columns: columns}, nil
+}
+
+func (c *csvMovieRowsReader) Read() (int, *movieInput, map[string]string, error) {
+	record, err := c.reader.Read()
+	if err == io.EOF {
+		return c.row, nil, nil, io.EOF
+	}
+	c.row++
+	if err != nil {
+		if errors.Is(err, csv.ErrFieldCount) {
+			return c.row, nil, map[string]string{"row": "Number of fields doesn't match the header"}, nil
+		}
+		return c.row, nil, nil, err
+	}
+	input := &movieInput{Title: record[c.columns["title"]]}
+	rowErrs := make(map[string]string)
+	if year, err := strconv.ParseInt(strings.TrimSpace(record[c.columns["year"]]), 10, 32); err != nil {
+		rowErrs["year"] = "Value must be an integer"
+	} else {
+		input.Year = int32(year)
+	}
+	if runtime, err := fields.ParseMovieRuntime(strings.TrimSpace(record[c.columns["runtime"]])); err != nil {
+		rowErrs["runtime"] = err.Error()
+	} else {
+		input.Runtime = runtime
+	}
+	if genres := strings.TrimSpace(record[c.columns["genres"]]); genres != "" {
+		for _, genre := range strings.Split(genres, ",") {
+			input.Genres = append(input.Genres, strings.TrimSpace(genre))
+		}
+	}
+	return c.row, input, rowErrs, nil
+}
+
+// ndjsonMovieRowsReader reads a JSON object per line, empty lines are skipped
+type ndjsonMovieRowsReader struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func (n *ndjsonMovieRowsReader) Read() (int, *movieInput, map[string]string, error) {
+	for n.scanner.Scan() {
+		n.row++
+		line := bytes.TrimSpace(n.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var input movieInput
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&input); err != nil {
+			return n.row, nil, map[string]string{"row": parseJsonErr(err).Error()}, nil
+		}
+		return n.row, &input, nil, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return n.row + 1, nil, nil, err
+	}
+	return n.row, nil, nil, io.EOF
+}
+
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv",
+	"ndjson": "application/x-ndjson",
+	"json":   "application/json",
+}
+
+// negotiateExportFormat picks export format by the Accept header, preferring the media types with higher quality.
+// Returns json if client accepts anything and empty string if none of the formats is acceptable
+func negotiateExportFormat(accept string) string {
+	if strings.TrimSpace(accept) == "" {
+		return "json"
+	}
+	format, bestQuality := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= bestQuality {
+			continue
+		}
+		switch mediaType {
+		case "*/*", "application/*":
+			format, bestQuality = "json", quality
+		case "application/ndjson":
+			format, bestQuality = "ndjson", quality
+		default:
+			for exportFormat, contentType := range exportContentTypes {
+				if contentType == mediaType {
+					format, bestQuality = exportFormat, quality
+				}
+			}
+		}
+	}
+	return format
+}
+
+// movieExportWriter encodes exported movies into the response body one by one
+type movieExportWriter interface {
+	Write(movie *models.Movie) error
+	// Close writes the rest of the document, it must be called even if there were no movies
+	Close() error
+}
+
+func newMovieExportWriter(w http.ResponseWriter, format string, withReviews bool) movieExportWriter {
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="movies.%s"`, format))
+	w.WriteHeader(http.StatusOK)
+	switch format {
+	case "csv":
+		return &csvMovieExportWriter{writer: csv.NewWriter(w), withReviews: withReviews}
+	case "ndjson":
+		return &jsonMovieExportWriter{w: w, encoder: json.NewEncoder(w), ndjson: true}
+	default:
+		return &jsonMovieExportWriter{w: w, encoder: json.NewEncoder(w)}
+	}
+}
+
+// csvMovieExportWriter writes movies in the same format the import accepts, so exported files can be imported back
+type csvMovieExportWriter struct {
+	writer        *csv.Writer
+	withReviews   bool
+	headerWritten bool
+}
+
+func (c *csvMovieExportWriter) writeHeader() error {
+	c.headerWritten = true
+	header := []string{"id", "title", "year", "runtime", "genres", "version", "user_id"}
+	if c.withReviews {
+		header = append(header, "reviews")
+	}
+	return c.writer.Write(header)
+}
+
+func (c *csvMovieExportWriter) Write(movie *models.Movie) error {
+	if !c.headerWritten {
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	record := []string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.Itoa(int(movie.Year)),
+		fmt.Sprintf("%d mins", movie.Runtime),
+		strings.Join(movie.Genres, ","),
+		strconv.FormatUint(uint64(movie.Version), 10),
+		strconv.FormatInt(movie.UserID, 10),
+	}
+	if c.withReviews {
+		reviews, err := json.Marshal(movie.Reviews)
+		if err != nil {
+			return err
+		}
+		record = append(record, string(reviews))
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvMovieExportWriter) Close() error {
+	if !c.headerWritten {
+		if err := c.writeHeader(); err != nil {
+			return err
+		}
+	}
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+// jsonMovieExportWriter writes either a JSON array of movies or a movie per line for ndjson
+type jsonMovieExportWriter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	ndjson  bool
+	started bool
+}
+
+func (j *jsonMovieExportWriter) Write(movie *models.Movie) error {
+	if !j.ndjson {
+		delim := ","
+		if !j.started {
+			delim = "["
+		}
+		if _, err := io.WriteString(j.w, delim); err != nil {
+			return err
+		}
+	}
+	j.started = true
+	return j.encoder.Encode(movie)
+}
+
+func (j *jsonMovieExportWriter) Close() error {
+	if j.ndjson {
+		return nil
+	}
+	closing := "]\n"
+	if !j.started {
+		closing = "[]\n"
+	}
+	_, err := io.WriteString(j.w, closing)
+	return err
+}
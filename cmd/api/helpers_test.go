@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"greenlight/proj/internal/domain/filters"
+	"greenlight/proj/internal/domain/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateExportFormat(t *testing.T) {
+	testCases := []struct {
+		accept         string
+		expectedFormat string
+	}{
+		{"", "json"},
+		{"*/*", "json"},
+		{"text/csv", "csv"},
+		{"application/x-ndjson", "ndjson"},
+		{"application/json;q=0.5, text/csv;q=0.9", "csv"},
+		{"text/html, */*;q=0.1", "json"},
+		{"text/html", ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.accept, func(t *testing.T) {
+			assert.Equal(t, testCase.expectedFormat, negotiateExportFormat(testCase.accept))
+		})
+	}
+}
+
+func TestETags(t *testing.T) {
+	representation := map[string]json.RawMessage{"id": json.RawMessage("7"), "average_rating": json.RawMessage("4.5")}
+	etag, err := movieETag(7, 3, representation)
+	require.NoError(t, err)
+	assert.Regexp(t, `^"7-3-[0-9a-f]{16}"$`, etag)
+	t.Run("representation", func(t *testing.T) {
+		same, err := movieETag(7, 3, map[string]json.RawMessage{"average_rating": json.RawMessage("4.5"), "id": json.RawMessage("7")})
+		require.NoError(t, err)
+		assert.Equal(t, etag, same)
+		changedAggregate, err := movieETag(7, 3, map[string]json.RawMessage{"id": json.RawMessage("7"), "average_rating": json.RawMessage("4")})
+		require.NoError(t, err)
+		assert.NotEqual(t, etag, changedAggregate)
+		projected, err := movieETag(7, 3, map[string]json.RawMessage{"id": json.RawMessage("7")})
+		require.NoError(t, err)
+		assert.NotEqual(t, etag, projected)
+	})
+	t.Run("if none match", func(t *testing.T) {
+		assert.True(t, etagMatchesAny(etag, etag))
+		assert.True(t, etagMatchesAny(`"1-1", W/`+etag, etag))
+		assert.True(t, etagMatchesAny(`*`, etag))
+		assert.False(t, etagMatchesAny(`"7-3"`, etag))
+	})
+	t.Run("if match", func(t *testing.T) {
+		testCases := []struct {
+			header          string
+			expectedVersion *uint
+		}{
+			{"", nil},
+			{"*", nil},
+			{`"7-3"`, ptr(uint(3))},
+			{`"7-3-0123456789abcdef"`, ptr(uint(3))},
+			{`"1-5", "7-4"`, ptr(uint(4))},
+			{`W/"7-3"`, ptr(uint(0))},
+			{`"8-3"`, ptr(uint(0))},
+		}
+		for _, testCase := range testCases {
+			request := httptest.NewRequest(http.MethodPatch, "/", nil)
+			request.Header.Set("If-Match", testCase.header)
+			assert.Equal(t, testCase.expectedVersion, ifMatchVersion(request, 7), testCase.header)
+		}
+	})
+}
+
+func TestProjectMovie(t *testing.T) {
+	movie := &models.Movie{ID: 1, Title: "Alien", Year: 1979, Version: 2, Reviews: []models.Review{}}
+	projected, err := projectMovie(movie, filters.Projection{Fields: []string{"id", "title"}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]json.RawMessage{"id": json.RawMessage("1"), "title": json.RawMessage(`"Alien"`)}, projected)
+
+	projected, err = projectMovie(movie, filters.Projection{Include: []string{filters.IncludeReviews}})
+	require.NoError(t, err)
+	assert.Contains(t, projected, "year")
+	assert.Equal(t, json.RawMessage("[]"), projected["reviews"])
+	assert.NotContains(t, projected, "rating_summary")
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
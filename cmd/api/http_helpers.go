@@ -1,13 +1,17 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"greenlight/proj/internal/config"
 	"greenlight/proj/internal/domain/models"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"strconv"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -68,6 +72,11 @@ func (h *Http) NoContent(w http.ResponseWriter, r *http.Request, msg string) {
 	h.Response(w, r, nil, msg, http.StatusNoContent)
 }
 
+func (h *Http) NotModified(w http.ResponseWriter, r *http.Request, etag string) {
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+}
+
 func (h *Http) BadRequest(w http.ResponseWriter, r *http.Request, msg string) {
 	h.Response(w, r, nil, msg, http.StatusBadRequest)
 }
@@ -85,10 +94,27 @@ func (h *Http) InvalidAuthToken(w http.ResponseWriter, r *http.Request) {
 	h.Unauthorized(w, r, "Invalid or expired authentication token in authorization header")
 }
 
+func (h *Http) InvalidApiKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "ApiKey")
+	h.Unauthorized(w, r, "Invalid, expired or revoked api key in authorization header")
+}
+
 func (h *Http) Conflict(w http.ResponseWriter, r *http.Request, msg string) {
 	h.Response(w, r, nil, msg, http.StatusConflict)
 }
 
+func (h *Http) NotAcceptable(w http.ResponseWriter, r *http.Request, msg string) {
+	h.Response(w, r, nil, msg, http.StatusNotAcceptable)
+}
+
+func (h *Http) UnsupportedMediaType(w http.ResponseWriter, r *http.Request, msg string) {
+	h.Response(w, r, nil, msg, http.StatusUnsupportedMediaType)
+}
+
+func (h *Http) PreconditionFailed(w http.ResponseWriter, r *http.Request, msg string) {
+	h.Response(w, r, nil, msg, http.StatusPreconditionFailed)
+}
+
 func (h *Http) UnprocessableEntity(w http.ResponseWriter, r *http.Request, errors map[string]string) {
 	h.Response(w, r, envelop{"errors": errors}, "", http.StatusUnprocessableEntity)
 }
@@ -117,6 +143,18 @@ func (h *Http) ServerError(w http.ResponseWriter, r *http.Request, err error, ms
 	render.JSON(w, r, Response{Success: false, Message: msg})
 }
 
+// ContextGetAccessToken returns the access token of the request, or nil if the request isn't authenticated with the bearer token
+func (h *Http) ContextGetAccessToken(r *http.Request) *models.AccessToken {
+	accessToken, _ := r.Context().Value(CtxKeyAccessToken).(*models.AccessToken)
+	return accessToken
+}
+
+// ContextGetApiKey returns the API key of the request, or nil if the request isn't authenticated with the key
+func (h *Http) ContextGetApiKey(r *http.Request) *models.ApiKey {
+	apiKey, _ := r.Context().Value(CtxKeyApiKey).(*models.ApiKey)
+	return apiKey
+}
+
 func (h *Http) ContextGetUser(r *http.Request) *models.User {
 	user, ok := r.Context().Value(CtxKeyUser).(*models.User)
 	if !ok {
@@ -137,3 +175,67 @@ func (h *Http) extractIDParam(w http.ResponseWriter, r *http.Request) (id int, e
 	}
 	return id, true
 }
+
+func (h *Http) extractPositiveIntParam(w http.ResponseWriter, r *http.Request, name string) (value int, extracted bool) {
+	value, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil {
+		h.BadRequest(w, r, fmt.Sprintf("invalid %s, must be an integer", name))
+		return 0, false
+	}
+	if value < 1 {
+		h.BadRequest(w, r, fmt.Sprintf("%s must be greater than zero", name))
+		return 0, false
+	}
+	return value, true
+}
+
+// movieETag builds strong entity tag of the movie representation. Besides id and version of the movie
+// it has the hash of the representation, so the tag differs for every projection and changes with
+// the data which doesn't bump the version, e.g. reviews and rating aggregates
+func movieETag(id int64, version uint, representation map[string]json.RawMessage) (string, error) {
+	b, err := json.Marshal(representation)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(b)
+	return fmt.Sprintf(`"%d-%d-%x"`, id, version, hash[:8]), nil
+}
+
+// etagMatchesAny reports whether header (If-None-Match like) contains the etag or "*".
+// Weak comparison is used, so W/ prefix is ignored
+func etagMatchesAny(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatchVersion extracts the movie version expected by the If-Match header.
+// Returns nil if the header is absent or is "*", as any version satisfies it then.
+// If none of the tags belongs to the movie, zero version is returned, which never matches the actual one
+func ifMatchVersion(r *http.Request, id int) *uint {
+	header := r.Header.Get("If-Match")
+	if strings.TrimSpace(header) == "" || strings.TrimSpace(header) == "*" {
+		return nil
+	}
+	var version uint
+	prefix := fmt.Sprintf(`"%d-`, id)
+	for _, candidate := range strings.Split(header, ",") {
+		// weak tags never match in If-Match, as it requires strong comparison
+		candidate = strings.TrimSpace(candidate)
+		if !strings.HasPrefix(candidate, prefix) || !strings.HasSuffix(candidate, `"`) {
+			continue
+		}
+		// the representation hash doesn't matter, the version alone identifies the state of the movie fields
+		versionPart, _, _ := strings.Cut(strings.TrimSuffix(strings.TrimPrefix(candidate, prefix), `"`), "-")
+		parsed, err := strconv.ParseUint(versionPart, 10, 0)
+		if err == nil {
+			version = uint(parsed)
+			break
+		}
+	}
+	return &version
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"expvar"
 	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/lib/jwtverifier"
+	"greenlight/proj/internal/services/apikeys"
 	"greenlight/proj/internal/services/auth"
 	"net/http"
 	"strconv"
@@ -13,7 +15,6 @@ import (
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
@@ -21,7 +22,12 @@ import (
 func (app *Application) Recoverer(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
-			if err := recover(); err != nil && err != http.ErrAbortHandler {
+			err := recover()
+			if err == http.ErrAbortHandler {
+				// net/http aborts the connection on this panic, so the client doesn't take partial response as complete
+				panic(err)
+			}
+			if err != nil {
 				app.log.Info("panic recovered", "err", err)
 				if _, ok := err.(error); !ok {
 					app.log.Error("Invalid error from panic", "err", err)
@@ -106,10 +112,12 @@ func (app *Application) enableCORS(allowedOrigins []string) func(next http.Handl
 						if (r.Method == http.MethodOptions) && r.Header.Get("Access-Control-Request-Method") != "" {
 							// Identified as a preflight request
 							w.Header().Set("Access-Control-Allow-Methods", "PUT, PATCH, DELETE, OPTIONS")
-							w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+							w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
 							w.WriteHeader(http.StatusOK)
 							return
 						}
+						// lets browser clients read entity tags for conditional requests
+						w.Header().Set("Access-Control-Expose-Headers", "ETag")
 						if allowedOrigin == "*" {
 							app.log.Warn("Be carefull, your service can be vulnerable to a distributed brute-force attack, if using '*' as allowed origin in conjuction with allowing Authorization header in cors requests")
 						}
@@ -127,62 +135,113 @@ func (app *Application) Authenticate(next http.Handler) http.Handler {
 		w.Header().Set("Vary", "Authorization")
 
 		var user *models.User = models.AnonymousUser
+		var accessToken *models.AccessToken
+		var apiKey *models.ApiKey
 
 		authHeader := r.Header.Get("Authorization")
 		if authHeader != "" {
-			app.log.Debug("Has auth header", "header", authHeader)
-			const bearerLength = len("Bearer ")
-			if !strings.HasPrefix(authHeader, "Bearer ") || len(authHeader) < bearerLength+1 {
-				app.log.Warn("Invalid auth header", "header", authHeader)
-				app.Http.BadRequest(w, r, "Invalid Authorization header, should have format: 'Bearer <token>'")
-				return
-			}
-			token := strings.TrimPrefix(authHeader, "Bearer ")
-			isValidToken, err := app.Services.Auth.VerifyToken(r.Context(), token)
-			if err != nil {
-				app.log.Error("Failed to verify token", "error", err)
-				app.Http.ServerError(w, r, err, "")
+			app.log.Debug("Has auth header")
+			scheme, credentials, _ := strings.Cut(authHeader, " ")
+			if credentials == "" || (scheme != "Bearer" && scheme != "ApiKey") {
+				app.log.Warn("Invalid auth header", "scheme", scheme)
+				app.Http.BadRequest(w, r, "Invalid Authorization header, should have format: 'Bearer <token>' or 'ApiKey <key>'")
 				return
 			}
-			if !isValidToken {
-				app.log.Warn("Invalid or expired token", "token", token)
-				app.Http.InvalidAuthToken(w, r)
-				return
+			var userID int64
+			var authenticated bool
+			if scheme == "Bearer" {
+				accessToken, authenticated = app.authenticateAccessToken(w, r, credentials)
+				if accessToken != nil {
+					userID = accessToken.UserID
+				}
+			} else {
+				apiKey, authenticated = app.authenticateApiKey(w, r, credentials)
+				if apiKey != nil {
+					userID = apiKey.UserID
+				}
 			}
-			parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
-				return []byte(app.cfg.AppSecret), nil
-			})
-			if err != nil {
-				app.log.Warn("Failed to parse token", "error", err)
-				app.Http.InvalidAuthToken(w, r)
+			if !authenticated {
 				return
 			}
-
-			if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok && parsedToken.Valid {
-				app.log.Debug("Has claims", "claims", claims)
-				userID, exists := claims["uid"].(float64)
-				if exists {
-					app.log.Debug("Has user id", "user_id", userID)
-					user, err = app.Services.Auth.GetUser(r.Context(), auth.GetUserParams{ID: int64(userID), IsActive: true})
-					if err != nil {
-						switch {
-						case errors.Is(err, auth.ErrUserNotFound):
-							app.log.Warn("user not found", "user_id", userID)
-							app.Http.InvalidAuthToken(w, r)
-						default:
-							app.log.Error("Failed to get user", "error", err)
-							app.Http.ServerError(w, r, err, "")
-						}
-						return
+			if userID != 0 {
+				var err error
+				user, err = app.Services.Auth.GetUser(r.Context(), auth.GetUserParams{ID: userID, IsActive: true})
+				if err != nil {
+					switch {
+					case errors.Is(err, auth.ErrUserNotFound):
+						app.log.Warn("user not found", "user_id", userID)
+						app.Http.InvalidAuthToken(w, r)
+					default:
+						app.log.Error("Failed to get user", "error", err)
+						app.Http.ServerError(w, r, err, "")
 					}
+					return
 				}
 			}
 		}
-		r = r.WithContext(context.WithValue(r.Context(), CtxKeyUser, user))
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), CtxKeyUser, user)
+		if accessToken != nil {
+			ctx = context.WithValue(ctx, CtxKeyAccessToken, accessToken)
+		}
+		if apiKey != nil {
+			ctx = context.WithValue(ctx, CtxKeyApiKey, apiKey)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// authenticateAccessToken verifies the bearer token and checks it isn't revoked. The token without uid claim
+// is valid, but identifies no user, so nil is returned for it. The error response is sent if authenticated is false
+func (app *Application) authenticateAccessToken(w http.ResponseWriter, r *http.Request, token string) (accessToken *models.AccessToken, authenticated bool) {
+	claims, err := app.Services.TokenVerifier.Verify(r.Context(), token)
+	if err != nil {
+		if errors.Is(err, jwtverifier.ErrInvalidToken) || errors.Is(err, jwtverifier.ErrUnknownKey) {
+			app.log.Warn("Invalid or expired token", "error", err)
+			app.Http.InvalidAuthToken(w, r)
+			return nil, false
+		}
+		app.log.Error("Failed to verify token", "error", err)
+		app.Http.ServerError(w, r, err, "")
+		return nil, false
+	}
+	app.log.Debug("Has claims", "claims", claims)
+	userID, exists := claims["uid"].(float64)
+	if !exists {
+		return nil, true
+	}
+	app.log.Debug("Has user id", "user_id", userID)
+	tokenInfo := app.Services.Auth.AccessTokenFromClaims(token, int64(userID), claims)
+	revoked, err := app.Services.Auth.IsAccessTokenRevoked(r.Context(), tokenInfo)
+	if err != nil {
+		app.log.Error("Failed to check token revocation", "error", err)
+		app.Http.ServerError(w, r, err, "")
+		return nil, false
+	}
+	if revoked {
+		app.log.Warn("Revoked token", "user_id", userID, "token_id", tokenInfo.ID)
+		app.Http.InvalidAuthToken(w, r)
+		return nil, false
+	}
+	return &tokenInfo, true
+}
+
+// authenticateApiKey looks up the active API key. The error response is sent if authenticated is false
+func (app *Application) authenticateApiKey(w http.ResponseWriter, r *http.Request, plainKey string) (apiKey *models.ApiKey, authenticated bool) {
+	apiKey, err := app.Services.ApiKeys.Authenticate(r.Context(), plainKey)
+	if err != nil {
+		if errors.Is(err, apikeys.ErrInvalidApiKey) {
+			app.log.Warn("Invalid api key")
+			app.Http.InvalidApiKey(w, r)
+			return nil, false
+		}
+		app.log.Error("Failed to authenticate api key", "error", err)
+		app.Http.ServerError(w, r, err, "")
+		return nil, false
+	}
+	app.log.Debug("Has api key", "key_id", apiKey.ID, "user_id", apiKey.UserID)
+	return apiKey, true
+}
+
 // Depends on Authenticate middleware
 func (app *Application) requireAuthenticatedUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -219,7 +278,7 @@ func (app *Application) requirePermission(permissionCode string) func(next http.
 			app.log.Debug("Checking permission", "permissionCode", permissionCode)
 			user := app.Http.ContextGetUser(r)
 			app.log.Debug("Got user from context", "user", user)
-			hasPermission, err := app.Services.Auth.CheckPermission(r.Context(), permissionCode, user.ID)
+			hasPermission, err := app.hasPermission(r, permissionCode)
 			if err != nil {
 				app.Http.ServerError(w, r, err, "")
 				return
@@ -236,6 +295,47 @@ func (app *Application) requirePermission(permissionCode string) func(next http.
 	}
 }
 
+// hasPermission checks the permission of the request user. Requests authenticated with API keys
+// are limited to the scopes of the key, unless the permission has been revoked from the user since
+func (app *Application) hasPermission(r *http.Request, permissionCode string) (bool, error) {
+	if apiKey := app.Http.ContextGetApiKey(r); apiKey != nil {
+		if !apiKey.HasScope(permissionCode) {
+			return false, nil
+		}
+		revoked, err := app.Services.Auth.IsPermissionRevoked(r.Context(), permissionCode, apiKey.UserID)
+		return !revoked, err
+	}
+	return app.Services.Auth.CheckPermission(r.Context(), permissionCode, app.Http.ContextGetUser(r).ID)
+}
+
+// requireUserCredentials rejects requests authenticated with API keys, e.g. so a leaked key can't issue new keys
+func (app *Application) requireUserCredentials(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.Http.ContextGetApiKey(r) != nil {
+			app.Http.Forbidden(w, r, "this resource can't be accessed with an api key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAdmin allows the request only if SSO considers the user an admin. API keys can't access admin resources
+func (app *Application) requireAdmin(next http.Handler) http.Handler {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isAdmin, err := app.Services.Auth.IsAdmin(r.Context(), app.Http.ContextGetUser(r).ID)
+		if err != nil {
+			app.Http.ServerError(w, r, err, "")
+			return
+		}
+		if !isAdmin {
+			app.Http.Forbidden(w, r, "you must be an admin to access this resource")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+	return app.requireActivatedUser(app.requireUserCredentials(fn))
+}
+
 func (app *Application) metrics(next http.Handler) http.Handler {
 	totalRequestsReceived := expvar.NewInt("total_requests_received")
 	totalResponsesSent := expvar.NewInt("total_responses_sent")
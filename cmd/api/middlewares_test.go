@@ -2,14 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"greenlight/proj/internal/config"
 	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/services/auth"
+	authmocks "greenlight/proj/internal/services/auth/mocks"
+	"greenlight/proj/internal/services/movies"
+	storagemocks "greenlight/proj/internal/storage/postgres/models/mocks"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCors(t *testing.T) {
@@ -158,4 +166,70 @@ func TestRateLimiter(t *testing.T) {
 			assert.Equal(t, expectedStatus, recorder.Code)
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestRequirePermissionWithApiKey(t *testing.T) {
+	// SSO mock has no expectations, so the test fails if the permission is checked by SSO
+	app := NewTestApplication(nil, t)
+	permissions := authmocks.NewPermissionStorage(t)
+	app.Services.Auth = auth.New(
+		app.log,
+		authmocks.NewMailProvider(t),
+		authmocks.NewSsoProvider(t),
+		authmocks.NewTaskExecutor(t),
+		authmocks.NewTokenStorage(t),
+		permissions,
+		authmocks.NewAccountStorage(t),
+		auth.TokensTTL{},
+		nil,
+		auth.Roles{},
+	)
+	permissions.On("IsUserPermissionRevoked", mock.Anything, int64(1), "movies:read").Return(false, nil)
+	permissions.On("IsUserPermissionRevoked", mock.Anything, int64(1), "reviews:moderate").Return(true, nil)
+	testUser := &models.User{ID: 1, Username: "test", Email: "test@gmail.com", IsActive: true}
+	apiKey := &models.ApiKey{ID: 1, UserID: testUser.ID, Scopes: []string{"movies:read", "reviews:moderate"}}
+	tests := []struct {
+		name       string
+		permission string
+		wantCode   int
+	}{
+		{name: "In scope", permission: "movies:read", wantCode: http.StatusOK},
+		{name: "Out of scope", permission: "movies:write", wantCode: http.StatusForbidden},
+		{name: "Revoked from user", permission: "reviews:moderate", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			ctx := context.WithValue(request.Context(), CtxKeyUser, testUser)
+			request = request.WithContext(context.WithValue(ctx, CtxKeyApiKey, apiKey))
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			app.requirePermission(tt.permission)(next).ServeHTTP(recorder, request)
+			assert.Equal(t, tt.wantCode, recorder.Code)
+		})
+	}
+}
+
+func TestRecovererAbortsExport(t *testing.T) {
+	// the rows cursor fails after the first movie is sent, so the response must not look complete
+	app := NewTestApplication(nil, t)
+	moviesStorage := storagemocks.NewMoviesStorage(t)
+	app.Services.Movies = movies.New(app.log, moviesStorage, storagemocks.NewReviewsStorage(t), []byte("secret"))
+	moviesStorage.On("Export", mock.Anything, mock.Anything, mock.Anything, false, mock.Anything).
+		Run(func(args mock.Arguments) {
+			fn := args.Get(4).(func(*models.Movie) error)
+			_ = fn(&models.Movie{ID: 1, Title: "Movie", Year: 2000, Genres: []string{"drama"}})
+		}).
+		Return(errors.New("connection reset"))
+	server := httptest.NewServer(app.Recoverer(http.HandlerFunc(app.exportMovies)))
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL + "?format=ndjson")
+	if err == nil {
+		defer response.Body.Close()
+		_, err = io.ReadAll(response.Body)
+	}
+	require.Error(t, err, "export must be aborted")
+}
@@ -34,19 +34,94 @@ func (app *Application) routes() http.Handler {
 				r.Use(app.requirePermission("movies:read"))
 				r.Get("/{id}", app.getMovie)
 				r.Get("/", app.getMovies)
+				r.Get("/export", app.exportMovies)
+				r.Get("/search", app.searchMovies)
+				r.Get("/{id}/revisions", app.getMovieRevisions)
+				r.Get("/{id}/revisions/{version}", app.getMovieRevision)
+				r.Get("/{id}/reviews", app.getMovieReviews)
 			})
 			r.Group(func(r chi.Router) {
 				r.Use(app.requirePermission("movies:write"))
 				r.Patch("/{id}", app.updateMovie)
 				r.Delete("/{id}", app.deleteMovie)
 				r.Post("/", app.createMovie)
+				r.Post("/import", app.importMovies)
+				r.Post("/{id}/revert/{version}", app.revertMovie)
 				r.Post("/{id}/review", app.addReviewForMovie)
 			})
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("movies:admin"))
+				r.Get("/trash", app.getTrashedMovies)
+				r.Post("/{id}/restore", app.restoreMovie)
+			})
+		})
+		r.Route("/reviews", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireActivatedUser)
+				r.Patch("/{id}", app.updateReview)
+				r.Delete("/{id}", app.deleteReview)
+				r.Post("/{id}/flag", app.flagReview)
+				r.Put("/{id}/vote", app.voteReview)
+				r.Delete("/{id}/vote", app.deleteReviewVote)
+				r.Post("/{id}/replies", app.addReviewReply)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("movies:read"))
+				r.Get("/{id}/replies", app.getReviewReplies)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("reviews:moderate"))
+				r.Get("/moderation", app.getModerationQueue)
+				r.Post("/{id}/moderate", app.moderateReview)
+			})
+		})
+		r.Route("/replies", func(r chi.Router) {
+			r.Use(app.requireActivatedUser)
+			r.Patch("/{id}", app.updateReply)
+			r.Delete("/{id}", app.deleteReply)
+		})
+		r.Route("/me", func(r chi.Router) {
+			r.Use(app.requireActivatedUser)
+			r.Get("/", app.getMe)
+			r.With(app.requireUserCredentials).Delete("/", app.deleteMe)
+			r.Get("/reviews", app.getMyReviews)
+			r.Get("/movies", app.getMyMovies)
+		})
+		r.Route("/users", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(app.requirePermission("movies:read"))
+				r.Get("/{id}/reviews", app.getUserReviews)
+				r.Get("/{id}/movies", app.getUserMovies)
+			})
+			r.Group(func(r chi.Router) {
+				r.Use(app.requireAdmin)
+				r.Get("/", app.findUser)
+				r.Post("/{id}/sessions/revoke", app.revokeUserSessions)
+				r.Get("/{id}/permissions", app.getUserPermissions)
+				r.Post("/{id}/permissions", app.grantUserPermissions)
+				r.Delete("/{id}/permissions/{code}", app.revokeUserPermission)
+				r.Put("/{id}/role", app.changeUserRole)
+			})
+		})
+		r.Route("/permissions", func(r chi.Router) {
+			r.Use(app.requireAdmin)
+			r.Get("/", app.getPermissions)
+			r.Post("/", app.createPermission)
+		})
+		r.With(app.requireAdmin).Get("/roles", app.getRoles)
+		r.Route("/api-keys", func(r chi.Router) {
+			r.Use(app.requirePermission("apikeys:manage"))
+			r.Use(app.requireUserCredentials)
+			r.Post("/", app.createApiKey)
+			r.Get("/", app.getApiKeys)
+			r.Delete("/{id}", app.revokeApiKey)
 		})
 		r.Route("/accounts", func(r chi.Router) {
 			r.Post("/activation/new-token", app.getNewActivationToken)
 			r.Put("/activation", app.activateAccount)
 			r.Post("/login", app.login)
+			r.Post("/tokens/refresh", app.refreshTokens)
+			r.With(app.requireAuthenticatedUser).Post("/logout", app.logout)
 			r.Post("/signup", app.signup)
 		})
 	})
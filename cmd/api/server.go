@@ -22,11 +22,18 @@ func (app *Application) serve() error {
 		ErrorLog:     logger.LogAdapter(app.log),
 	}
 	shutdownErrs := make(chan error)
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go app.purgeTrash(purgeCtx)
+	go app.reloadContentFilter(purgeCtx)
+	go app.purgeExpiredTokens(purgeCtx)
+	go app.syncRoles(purgeCtx)
 	go func() {
 		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
 		sig := <-ch
 		app.log.Info("shutting down the server gracefully", "signal", sig.String())
+		stopPurge()
 		ctx, cancel := context.WithTimeout(context.Background(), app.cfg.Server.ShutdownTimeout)
 		defer cancel()
 		shutdownErrs <- server.Shutdown(ctx)
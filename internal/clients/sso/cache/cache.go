@@ -0,0 +1,212 @@
+// Package cache decorates auth.SsoProvider with the short-lived cache of the lookups: users, permission checks,
+// admin checks and token verifications. Concurrent lookups of the same entry make a single call to SSO
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"expvar"
+	"fmt"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/lib/lru"
+	"greenlight/proj/internal/services/auth"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// metrics count hits and misses per method, e.g. GetUser.hits
+var metrics = expvar.NewMap("sso_cache")
+
+// Options are lifetimes of the entries per method, the method isn't cached if its TTL is zero.
+// Capacity bounds the number of entries of every method
+type Options struct {
+	Capacity      int
+	UserTTL       time.Duration
+	PermissionTTL time.Duration
+	AdminTTL      time.Duration
+	TokenTTL      time.Duration
+}
+
+type permissionKey struct {
+	code   string
+	userID int64
+}
+
+// Client caches the lookups of the wrapped provider. Writes made through the client invalidate the entries
+// of the affected user right away. Permission revocations and closed accounts are stored by the app
+// and checked on top of SSO, so they don't depend on the cache
+type Client struct {
+	auth.SsoProvider
+	options     Options
+	users       *lru.Cache[auth.GetUserParams, *models.User]
+	permissions *lru.Cache[permissionKey, bool]
+	admins      *lru.Cache[int64, bool]
+	tokens      *lru.Cache[[sha256.Size]byte, bool]
+	group       singleflight.Group
+
+	// generation is incremented on every invalidation, so the lookup which has started before it isn't cached.
+	// inflight are the generations of the started lookups by their keys in group
+	mu         sync.Mutex
+	generation uint64
+	inflight   map[string]uint64
+}
+
+func New(sso auth.SsoProvider, options Options) *Client {
+	return &Client{
+		SsoProvider: sso,
+		options:     options,
+		users:       lru.New[auth.GetUserParams, *models.User](options.Capacity),
+		permissions: lru.New[permissionKey, bool](options.Capacity),
+		admins:      lru.New[int64, bool](options.Capacity),
+		tokens:      lru.New[[sha256.Size]byte, bool](options.Capacity),
+		inflight:    make(map[string]uint64),
+	}
+}
+
+func (c *Client) GetUser(ctx context.Context, params auth.GetUserParams) (*models.User, error) {
+	user, err := cached(ctx, c, "GetUser", c.users, c.options.UserTTL, params, c.SsoProvider.GetUser)
+	if err != nil {
+		return nil, err
+	}
+	// callers may change the user, so they get the copy of the cached one
+	userCopy := *user
+	return &userCopy, nil
+}
+
+func (c *Client) CheckPermission(ctx context.Context, permissionCode string, userID int64) (bool, error) {
+	key := permissionKey{code: permissionCode, userID: userID}
+	return cached(ctx, c, "CheckPermission", c.permissions, c.options.PermissionTTL, key, func(ctx context.Context, key permissionKey) (bool, error) {
+		return c.SsoProvider.CheckPermission(ctx, key.code, key.userID)
+	})
+}
+
+func (c *Client) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	return cached(ctx, c, "IsAdmin", c.admins, c.options.AdminTTL, userID, c.SsoProvider.IsAdmin)
+}
+
+func (c *Client) VerifyToken(ctx context.Context, token string) (bool, error) {
+	// tokens are cached by their hashes, so the cache doesn't keep the credentials
+	ttl := tokenTTL(token, c.options.TokenTTL)
+	return cached(ctx, c, "VerifyToken", c.tokens, ttl, sha256.Sum256([]byte(token)), func(ctx context.Context, _ [sha256.Size]byte) (bool, error) {
+		return c.SsoProvider.VerifyToken(ctx, token)
+	})
+}
+
+// tokenTTL caps ttl at the expiry of the token, so the token isn't verified from the cache once it has expired.
+// Expired tokens aren't cached. The claims are read unverified, SSO verifies the token
+func tokenTTL(token string, ttl time.Duration) time.Duration {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return ttl
+	}
+	expiresAt, err := claims.GetExpirationTime()
+	if err != nil || expiresAt == nil {
+		return ttl
+	}
+	return min(ttl, time.Until(expiresAt.Time))
+}
+
+func (c *Client) GrantPermissions(ctx context.Context, userID int64, permissions []string) error {
+	defer c.InvalidatePermissions(userID)
+	return c.SsoProvider.GrantPermissions(ctx, userID, permissions)
+}
+
+func (c *Client) ActivateUser(ctx context.Context, plainToken string) (*models.User, error) {
+	user, err := c.SsoProvider.ActivateUser(ctx, plainToken)
+	if err != nil {
+		return nil, err
+	}
+	c.InvalidateUser(user.ID)
+	return user, nil
+}
+
+// InvalidateUser deletes the cached lookups of the user, found by ID or by email
+func (c *Client) InvalidateUser(userID int64) {
+	c.invalidate("GetUser", func() {
+		c.users.DeleteFunc(func(_ auth.GetUserParams, user *models.User) bool { return user.ID == userID })
+	})
+}
+
+// InvalidatePermissions deletes the cached permission checks of the user
+func (c *Client) InvalidatePermissions(userID int64) {
+	c.invalidate("CheckPermission", func() {
+		c.permissions.DeleteFunc(func(key permissionKey, _ bool) bool { return key.userID == userID })
+	})
+}
+
+// invalidate deletes the entries and forgets the lookups of the method in flight, so the callers coming after
+// the invalidation start the new lookup instead of getting the result of the stale one
+func (c *Client) invalidate(method string, deleteEntries func()) {
+	c.mu.Lock()
+	c.generation++
+	for key := range c.inflight {
+		if strings.HasPrefix(key, method+":") {
+			c.group.Forget(key)
+			delete(c.inflight, key)
+		}
+	}
+	c.mu.Unlock()
+	deleteEntries()
+}
+
+// startLoad records the lookup in flight and returns the current generation
+func (c *Client) startLoad(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.inflight[key] = c.generation
+	return c.generation
+}
+
+// cached returns the cached value of the key, or loads it. Concurrent loads of the same key are shared,
+// so the load isn't canceled with the context of the request which has started it, but every caller
+// stops waiting for it once its own context is done. Errors aren't cached
+func cached[K comparable, V any](
+	ctx context.Context,
+	c *Client,
+	method string,
+	cache *lru.Cache[K, V],
+	ttl time.Duration,
+	key K,
+	load func(ctx context.Context, key K) (V, error),
+) (V, error) {
+	if ttl <= 0 {
+		return load(ctx, key)
+	}
+	if value, exists := cache.Get(key); exists {
+		metrics.Add(method+".hits", 1)
+		return value, nil
+	}
+	metrics.Add(method+".misses", 1)
+	groupKey := fmt.Sprintf("%s:%v", method, key)
+	loaded := c.group.DoChan(groupKey, func() (any, error) {
+		generation := c.startLoad(groupKey)
+		value, err := load(context.WithoutCancel(ctx), key)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// the lookup which has started after the invalidation may be in flight under the same key
+		if started, ok := c.inflight[groupKey]; ok && started == generation {
+			delete(c.inflight, groupKey)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if generation == c.generation {
+			cache.Set(key, value, ttl)
+		}
+		return value, nil
+	})
+	var zero V
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case result := <-loaded:
+		if result.Err != nil {
+			return zero, result.Err
+		}
+		return result.Val.(V), nil
+	}
+}
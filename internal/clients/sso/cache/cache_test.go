@@ -0,0 +1,191 @@
+package cache
+
+import (
+	"context"
+	"expvar"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/services/auth"
+	authmocks "greenlight/proj/internal/services/auth/mocks"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(t *testing.T) (*Client, *authmocks.SsoProvider) {
+	sso := authmocks.NewSsoProvider(t)
+	options := Options{Capacity: 100, UserTTL: time.Minute, PermissionTTL: time.Minute, AdminTTL: time.Minute}
+	return New(sso, options), sso
+}
+
+func metricValue(key string) int64 {
+	if value, ok := metrics.Get(key).(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+func TestCheckPermission(t *testing.T) {
+	client, sso := newTestClient(t)
+	ctx := context.Background()
+	sso.On("CheckPermission", mock.Anything, "movies:write", int64(1)).Return(false, nil).Once()
+	hits := metricValue("CheckPermission.hits")
+
+	for range 2 {
+		granted, err := client.CheckPermission(ctx, "movies:write", 1)
+		require.NoError(t, err)
+		assert.False(t, granted)
+	}
+	assert.Equal(t, hits+1, metricValue("CheckPermission.hits"))
+
+	sso.On("GrantPermissions", mock.Anything, int64(1), []string{"movies:write"}).Return(nil).Once()
+	sso.On("CheckPermission", mock.Anything, "movies:write", int64(1)).Return(true, nil).Once()
+	require.NoError(t, client.GrantPermissions(ctx, 1, []string{"movies:write"}))
+	granted, err := client.CheckPermission(ctx, "movies:write", 1)
+	require.NoError(t, err)
+	assert.True(t, granted, "grant must invalidate the cached check")
+}
+
+func TestGetUser(t *testing.T) {
+	client, sso := newTestClient(t)
+	ctx := context.Background()
+	params := auth.GetUserParams{ID: 1, IsActive: true}
+	user := &models.User{ID: 1, Email: "test@gmail.com", IsActive: true}
+	sso.On("GetUser", mock.Anything, params).After(50*time.Millisecond).Return(user, nil).Once()
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := client.GetUser(ctx, params)
+			assert.NoError(t, err)
+			assert.Equal(t, user, got)
+		}()
+	}
+	wg.Wait()
+
+	got, _ := client.GetUser(ctx, params)
+	got.Username = "changed"
+	got, _ = client.GetUser(ctx, params)
+	assert.Empty(t, got.Username, "callers must not change the cached user")
+
+	sso.On("ActivateUser", mock.Anything, "token").Return(user, nil).Once()
+	sso.On("GetUser", mock.Anything, params).Return(user, nil).Once()
+	_, err := client.ActivateUser(ctx, "token")
+	require.NoError(t, err)
+	_, err = client.GetUser(ctx, params)
+	require.NoError(t, err)
+}
+
+func TestErrorsAreNotCached(t *testing.T) {
+	client, sso := newTestClient(t)
+	sso.On("IsAdmin", mock.Anything, int64(1)).Return(false, assert.AnError).Once()
+	sso.On("IsAdmin", mock.Anything, int64(1)).Return(true, nil).Once()
+	_, err := client.IsAdmin(context.Background(), 1)
+	assert.ErrorIs(t, err, assert.AnError)
+	isAdmin, err := client.IsAdmin(context.Background(), 1)
+	require.NoError(t, err)
+	assert.True(t, isAdmin)
+}
+
+func TestDisabledMethod(t *testing.T) {
+	client, sso := newTestClient(t)
+	sso.On("VerifyToken", mock.Anything, "token").Return(true, nil).Twice()
+	for range 2 {
+		valid, err := client.VerifyToken(context.Background(), "token")
+		require.NoError(t, err)
+		assert.True(t, valid)
+	}
+}
+
+func TestWaiterContext(t *testing.T) {
+	client, sso := newTestClient(t)
+	release := make(chan time.Time)
+	sso.On("IsAdmin", mock.Anything, int64(1)).WaitUntil(release).Return(true, nil).Once()
+	go func() {
+		_, _ = client.IsAdmin(context.Background(), 1)
+	}()
+
+	// the waiter gives up on its own deadline, while the shared load goes on
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.IsAdmin(ctx, 1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	close(release)
+	assert.Eventually(t, func() bool {
+		isAdmin, err := client.IsAdmin(context.Background(), 1)
+		return err == nil && isAdmin
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestVerifyTokenExpiry(t *testing.T) {
+	sso := authmocks.NewSsoProvider(t)
+	client := New(sso, Options{Capacity: 100, TokenTTL: time.Hour})
+	signToken := func(expiresAt time.Time) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": expiresAt.Unix()}).SignedString([]byte("secret"))
+		require.NoError(t, err)
+		return token
+	}
+	t.Run("active token is cached", func(t *testing.T) {
+		token := signToken(time.Now().Add(2 * time.Hour))
+		sso.On("VerifyToken", mock.Anything, token).Return(true, nil).Once()
+		for range 2 {
+			valid, err := client.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+			assert.True(t, valid)
+		}
+	})
+	t.Run("expired token isn't cached", func(t *testing.T) {
+		token := signToken(time.Now().Add(-time.Minute))
+		sso.On("VerifyToken", mock.Anything, token).Return(false, nil).Twice()
+		for range 2 {
+			valid, err := client.VerifyToken(context.Background(), token)
+			require.NoError(t, err)
+			assert.False(t, valid)
+		}
+	})
+	t.Run("ttl is capped at expiry", func(t *testing.T) {
+		ttl := tokenTTL(signToken(time.Now().Add(10*time.Minute)), time.Hour)
+		assert.InDelta(t, 10*time.Minute, ttl, float64(2*time.Second))
+		assert.Equal(t, time.Hour, tokenTTL("opaque-token", time.Hour))
+	})
+}
+
+func TestInvalidationForgetsLookupInFlight(t *testing.T) {
+	client, sso := newTestClient(t)
+	ctx := context.Background()
+	params := auth.GetUserParams{ID: 1}
+	staleUser := &models.User{ID: 1, IsActive: false}
+	activeUser := &models.User{ID: 1, IsActive: true}
+	release := make(chan time.Time)
+	sso.On("GetUser", mock.Anything, params).WaitUntil(release).Return(staleUser, nil).Once()
+	staleLoaded := make(chan struct{})
+	go func() {
+		defer close(staleLoaded)
+		_, _ = client.GetUser(ctx, params)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// the caller coming after the activation mustn't join the lookup which has started before it
+	sso.On("ActivateUser", mock.Anything, "token").Return(activeUser, nil).Once()
+	sso.On("GetUser", mock.Anything, params).Return(activeUser, nil).Once()
+	_, err := client.ActivateUser(ctx, "token")
+	require.NoError(t, err)
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	user, err := client.GetUser(timeoutCtx, params)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive)
+
+	close(release)
+	<-staleLoaded
+	user, err = client.GetUser(ctx, params)
+	require.NoError(t, err)
+	assert.True(t, user.IsActive, "stale lookup must not be cached")
+}
@@ -85,7 +85,7 @@ func New(
 }
 
 func (c *Client) IsAdmin(ctx context.Context, userID int64) (bool, error) {
-	const op = "grpc.Client.GetUser"
+	const op = "grpc.Client.IsAdmin"
 	log := c.log.With("op", op)
 	resp, err := c.api.IsAdmin(ctx, &ssov1.IsAdminRequest{UserId: userID})
 	if err != nil {
@@ -221,6 +221,9 @@ func (c *Client) CreatePermission(ctx context.Context, code string) error {
 	log := c.log.With("op", op)
 	_, err := c.api.CreatePermission(ctx, &ssov1.CreatePermissionRequest{Code: code})
 	if err != nil {
+		if grpcErr, ok := status.FromError(err); ok && grpcErr.Code() == codes.AlreadyExists {
+			return auth.ErrPermissionExists
+		}
 		log.Error("Error", "errMsg", err.Error())
 		return err
 	}
@@ -249,6 +252,26 @@ func (c *Client) GrantPermissions(ctx context.Context, userID int64, permissions
 	return nil
 }
 
+// RenewAccessToken exchanges the SSO refresh token for the new access token.
+// Returns auth.ErrInvalidRefreshToken if SSO rejects the refresh token
+func (c *Client) RenewAccessToken(ctx context.Context, refreshToken string) (string, error) {
+	const op = "grpc.Client.RenewAccessToken"
+	log := c.log.With("op", op)
+	resp, err := c.api.RenewAccessToken(ctx, &ssov1.RenewAccessTokenRequest{RefreshToken: refreshToken, AppId: c.appId})
+	if err != nil {
+		grpcErr, ok := status.FromError(err)
+		if ok {
+			switch grpcErr.Code() {
+			case codes.Unauthenticated, codes.InvalidArgument, codes.NotFound:
+				return "", auth.ErrInvalidRefreshToken
+			}
+		}
+		log.Error("Error", "errMsg", err.Error())
+		return "", err
+	}
+	return resp.GetAccessToken(), nil
+}
+
 // Adapter for grpclogging.Logger used to adapt it to slog.Logger
 func InterceptorLogger(log *slog.Logger) grpclogging.Logger {
 	return grpclogging.LoggerFunc(
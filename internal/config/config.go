@@ -10,15 +10,73 @@ import (
 )
 
 type Config struct {
-	Debug      bool          `yaml:"debug"`
-	Limiter    Limiter       `yaml:"limiter"`
-	AppID      int32         `yaml:"app_id"`
-	AppSecret  string        `yaml:"app_secret"`
-	Server     server        `yaml:"server"`
-	DB         db            `yaml:"db"`
-	Clients    clientsConfig `yaml:"clients"`
-	SMTPServer smtp          `yaml:"smtp_server"`
-	CORS       Cors          `yaml:"cors"`
+	Debug         bool          `yaml:"debug"`
+	Limiter       Limiter       `yaml:"limiter"`
+	AppID         int32         `yaml:"app_id"`
+	AppSecret     string        `yaml:"app_secret"`
+	Server        server        `yaml:"server"`
+	DB            db            `yaml:"db"`
+	Clients       clientsConfig `yaml:"clients"`
+	SMTPServer    smtp          `yaml:"smtp_server"`
+	CORS          Cors          `yaml:"cors"`
+	Movies        movies        `yaml:"movies"`
+	Reviews       reviews       `yaml:"reviews"`
+	ContentFilter contentFilter `yaml:"content_filter"`
+	Tokens        tokens        `yaml:"tokens"`
+	JWT           jwtConfig     `yaml:"jwt"`
+	Roles         roles         `yaml:"roles"`
+}
+
+// roles maps the role names to the bundles of permission codes. Users are re-synced on start
+// if the definitions have changed
+type roles struct {
+	Default     string              `yaml:"default" env-default:"user"` // Role assigned at signup
+	Definitions map[string][]string `yaml:"definitions"`
+}
+
+// jwtConfig configures local verification of the access tokens issued by SSO.
+// If no keys are set, tokens are verified with app_secret using HS256
+type jwtConfig struct {
+	Algorithms  []string      `yaml:"algorithms" env-default:"HS256"`
+	Issuer      string        `yaml:"issuer"`   // Not checked if empty
+	Audience    string        `yaml:"audience"` // Not checked if empty
+	Leeway      time.Duration `yaml:"leeway" env-default:"30s"`
+	DefaultKey  string        `yaml:"default_kid"`  // Key for the tokens without kid header
+	SSOFallback bool          `yaml:"sso_fallback"` // Verify tokens signed with unknown keys by SSO
+	Keys        []jwtKey      `yaml:"keys"`
+}
+
+// jwtKey is the key verifying tokens with the kid. HMAC keys have the secret, or the name of env variable with it,
+// while the asymmetric ones have the PEM file with the public key
+type jwtKey struct {
+	ID            string `yaml:"kid"`
+	Algorithm     string `yaml:"algorithm"`
+	Secret        string `yaml:"secret"`
+	SecretEnv     string `yaml:"secret_env"`
+	PublicKeyFile string `yaml:"public_key_file"`
+}
+
+// tokens configures lifetimes of the tokens, their encryption and cleanup of the expired ones
+type tokens struct {
+	AccessTTL                time.Duration `yaml:"access_ttl" env-default:"1h"`                                    // Not less than the lifetime of access tokens issued by SSO
+	RefreshTTL               time.Duration `yaml:"refresh_ttl" env-default:"720h"`                                 // Lifetime of the login, refresh tokens don't prolong it
+	RevocationsPurgeInterval time.Duration `yaml:"revocations_purge_interval" env-default:"1h"`                    // Not positive disables the purge
+	EncryptionKey            string        `yaml:"encryption_key" env-required:"true" env:"TOKENS_ENCRYPTION_KEY"` // Hex encoded 256 bits key encrypting SSO refresh tokens
+}
+
+// contentFilter configures checks of the user submitted texts. Rules are reloaded when the file changes
+type contentFilter struct {
+	RulesPath      string        `yaml:"rules_path"`
+	ReloadInterval time.Duration `yaml:"reload_interval" env-default:"30s"` // Not positive disables the reload
+}
+
+type movies struct {
+	TrashRetention     time.Duration `yaml:"trash_retention" env-default:"720h"`
+	TrashPurgeInterval time.Duration `yaml:"trash_purge_interval" env-default:"1h"` // Not positive disables the purge
+}
+
+type reviews struct {
+	FlagsThreshold int `yaml:"flags_threshold" env-default:"3"` // Number of user flags hiding the approved review until moderation
 }
 
 type smtp struct {
@@ -45,7 +103,18 @@ type client struct {
 }
 
 type clientsConfig struct {
-	SSO client `yaml:"sso"`
+	SSO      client   `yaml:"sso"`
+	SSOCache ssoCache `yaml:"sso_cache"`
+}
+
+// ssoCache configures the cache of SSO lookups. Zero TTL disables the cache of the lookup
+type ssoCache struct {
+	Enabled       bool          `yaml:"enabled" env-default:"true"`
+	Capacity      int           `yaml:"capacity" env-default:"10000"` // Entries per cached method
+	UserTTL       time.Duration `yaml:"user_ttl" env-default:"30s"`
+	PermissionTTL time.Duration `yaml:"permission_ttl" env-default:"30s"`
+	AdminTTL      time.Duration `yaml:"admin_ttl" env-default:"30s"`
+	TokenTTL      time.Duration `yaml:"token_ttl" env-default:"30s"`
 }
 type server struct {
 	Port string `yaml:"port" env-default:"8000"`
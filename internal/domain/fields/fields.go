@@ -13,22 +13,34 @@ func (m MovieRuntime) MarshalJSON() ([]byte, error) {
 }
 
 func (m *MovieRuntime) UnmarshalJSON(b []byte) error {
-	invalidFormatErr := fmt.Errorf(
-		"invalid runtime format, must be '<number> mins' (e.g. '120 mins'). Got %s",
-		string(b),
-	)
 	s, err := strconv.Unquote(string(b))
 	if err != nil {
-		return invalidFormatErr
+		return invalidRuntimeFormatErr(string(b))
 	}
+	runtime, err := ParseMovieRuntime(s)
+	if err != nil {
+		return err
+	}
+	*m = runtime
+	return nil
+}
+
+// ParseMovieRuntime parses runtime in the format '<number> mins' (e.g. '120 mins')
+func ParseMovieRuntime(s string) (MovieRuntime, error) {
 	parts := strings.Split(s, " ")
 	if len(parts) != 2 || parts[1] != "mins" {
-		return invalidFormatErr
+		return 0, invalidRuntimeFormatErr(strconv.Quote(s))
 	}
 	i, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return err
+		return 0, err
 	}
-	*m = MovieRuntime(i)
-	return nil
+	return MovieRuntime(i), nil
+}
+
+func invalidRuntimeFormatErr(got string) error {
+	return fmt.Errorf(
+		"invalid runtime format, must be '<number> mins' (e.g. '120 mins'). Got %s",
+		got,
+	)
 }
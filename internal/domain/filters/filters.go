@@ -1,7 +1,16 @@
 package filters
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"greenlight/proj/internal/utils"
+	"maps"
+	"reflect"
+	"slices"
 	"strings"
 )
 
@@ -10,18 +19,93 @@ const (
 	DescSort = "DESC"
 )
 
+const (
+	CursorNext = "next"
+	CursorPrev = "prev"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafelist []string
+	SortAliases  map[string]string // Maps alternative sort names to the safelist values
+	Cursor       *Cursor           // Enables keyset pagination when set instead of page/offset one
+}
+
+// Cursor points to the record relative to which the next (or previous) page is selected in keyset pagination.
+// It's passed to clients as an opaque signed token, so the sort is stored as well to detect cursors issued for another ordering.
+// Value keeps the sort column of the record as it was when the cursor was issued, so the record isn't read again
+type Cursor struct {
+	Sort      string          `json:"s"`
+	LastID    int64           `json:"id"`
+	Value     json.RawMessage `json:"v,omitempty"`
+	Null      bool            `json:"n,omitempty"` // Sort column of the record is NULL
+	Direction string          `json:"d"`
+}
+
+// Encode signs the cursor with the key, so its value can't be forged by clients
+func (c *Cursor) Encode(key []byte) string {
+	b, err := json.Marshal(c)
+	if err != nil {
+		panic(err)
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(cursorSignature(payload, key))
+}
+
+func DecodeCursor(token string, key []byte) (*Cursor, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidCursor
+	}
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, cursorSignature(payload, key)) {
+		return nil, ErrInvalidCursor
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor Cursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.LastID < 1 || (cursor.Direction != CursorNext && cursor.Direction != CursorPrev) || cursor.Null != (cursor.Value == nil) {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+func cursorSignature(payload string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// StructSortSafelist lists fields of the struct which are stored in db, so the records can be ordered by them
+func StructSortSafelist(v any) []string {
+	t := reflect.TypeOf(v)
+	fields := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("db") == "-" {
+			continue
+		}
+		fields = append(fields, t.Field(i).Name)
+	}
+	return fields
 }
 
 func (f *Filters) SortColumn() string {
-	s := strings.TrimPrefix(f.Sort, "-")
+	s := strings.ReplaceAll(strings.TrimPrefix(f.Sort, "-"), "_", "")
+	if alias, ok := f.SortAliases[strings.ToLower(s)]; ok {
+		s = alias
+	}
 	for _, safeValue := range f.SortSafelist {
 		if strings.EqualFold(s, safeValue) {
-			return s
+			return utils.CamelToSnake(safeValue)
 		}
 	}
 	panic(errors.New("Unknown sort column: " + f.Sort))
@@ -41,3 +125,243 @@ func (f *Filters) Limit() int {
 func (f Filters) Offset() int {
 	return (f.Page - 1) * f.PageSize
 }
+
+// IsBackward reports whether the page preceding the cursor is requested
+func (f *Filters) IsBackward() bool {
+	return f.Cursor != nil && f.Cursor.Direction == CursorPrev
+}
+
+// KeysetDirection returns the direction rows are read in. For backward pages it's the opposite of the sort direction,
+// so rows closest to the cursor come first and the page has to be reversed afterwards
+func (f *Filters) KeysetDirection() string {
+	direction := f.SortDirection()
+	if f.IsBackward() {
+		if direction == AscSort {
+			return DescSort
+		}
+		return AscSort
+	}
+	return direction
+}
+
+// KeysetOperator returns the comparison operator used to select rows after the cursor in the read direction
+func (f *Filters) KeysetOperator() string {
+	if f.KeysetDirection() == AscSort {
+		return ">"
+	}
+	return "<"
+}
+
+// KeysetLimit requests one extra row to find out whether there is a page beyond the current one
+func (f *Filters) KeysetLimit() int {
+	return f.PageSize + 1
+}
+
+func (f *Filters) CursorID() int64 {
+	if f.Cursor == nil {
+		return 0
+	}
+	return f.Cursor.LastID
+}
+
+// NewCursor points to the record, which is the struct sorted by the filters, in the direction
+func (f *Filters) NewCursor(record any, id int64, direction string) *Cursor {
+	cursor := &Cursor{Sort: f.Sort, LastID: id, Direction: direction}
+	value := reflect.ValueOf(record).FieldByIndex(f.sortField(reflect.TypeOf(record)).Index)
+	if value.Kind() == reflect.Pointer && value.IsNil() {
+		cursor.Null = true
+		return cursor
+	}
+	b, err := json.Marshal(value.Interface())
+	if err != nil {
+		panic(err)
+	}
+	cursor.Value = b
+	return cursor
+}
+
+// CursorValue returns the sort column value of the cursor typed as the field of the record struct,
+// so it can be compared to the column in db. Nil is returned for the NULL value
+func (f *Filters) CursorValue(record any) (any, error) {
+	if f.Cursor == nil || f.Cursor.Null {
+		return nil, nil
+	}
+	value := reflect.New(f.sortField(reflect.TypeOf(record)).Type)
+	if err := json.Unmarshal(f.Cursor.Value, value.Interface()); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return value.Elem().Interface(), nil
+}
+
+// sortField finds the field of the struct stored in the sort column
+func (f *Filters) sortField(t reflect.Type) reflect.StructField {
+	column := f.SortColumn()
+	for i := 0; i < t.NumField(); i++ {
+		if utils.CamelToSnake(t.Field(i).Name) == column {
+			return t.Field(i)
+		}
+	}
+	panic(errors.New("Unknown sort field: " + f.Sort))
+}
+
+type Operator string
+
+const (
+	OpEq  Operator = "="
+	OpGt  Operator = ">"
+	OpGte Operator = ">="
+	OpLt  Operator = "<"
+	OpLte Operator = "<="
+)
+
+// Condition compares the field with the value using the operator
+type Condition struct {
+	Field    string
+	Operator Operator
+	Value    any
+}
+
+// MovieFilters is a typed set of filters applied to the movies listing
+type MovieFilters struct {
+	Title      string
+	Genres     []string
+	Conditions []Condition
+	Deleted    bool  // Selects movies from the trash instead of the live ones
+	UserID     int64 // Selects movies added by the user if set
+}
+
+// RangeFilter is implemented by Range of any type, so the ranges for the different fields can be collected together
+type RangeFilter interface {
+	Validate() map[string]string
+	Conditions() []Condition
+}
+
+// Range holds optional bounds for a single field. Eq is an exact match and can't be combined with other bounds
+type Range[T any] struct {
+	Field   string
+	Eq      *T
+	Gt      *T
+	Gte     *T
+	Lt      *T
+	Lte     *T
+	compare func(a, b T) int
+}
+
+func NewRange[T any](field string, compare func(a, b T) int, eq, gt, gte, lt, lte *T) *Range[T] {
+	return &Range[T]{Field: field, Eq: eq, Gt: gt, Gte: gte, Lt: lt, Lte: lte, compare: compare}
+}
+
+// Validate checks that the bounds are consistent. Errors are keyed by query param names (e.g. year_gte)
+func (r *Range[T]) Validate() map[string]string {
+	errs := make(map[string]string)
+	key := func(suffix string) string { return r.Field + "_" + suffix }
+	if r.Eq != nil && (r.Gt != nil || r.Gte != nil || r.Lt != nil || r.Lte != nil) {
+		errs[r.Field] = fmt.Sprintf("Exact value can't be combined with the range bounds of %s", r.Field)
+		return errs
+	}
+	if r.Gt != nil && r.Gte != nil {
+		errs[key("gt")] = fmt.Sprintf("Can't be combined with %s", key("gte"))
+	}
+	if r.Lt != nil && r.Lte != nil {
+		errs[key("lt")] = fmt.Sprintf("Can't be combined with %s", key("lte"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	lower, lowerKey, lowerStrict := r.Gte, key("gte"), false
+	if r.Gt != nil {
+		lower, lowerKey, lowerStrict = r.Gt, key("gt"), true
+	}
+	upper, upperKey, upperStrict := r.Lte, key("lte"), false
+	if r.Lt != nil {
+		upper, upperKey, upperStrict = r.Lt, key("lt"), true
+	}
+	if lower != nil && upper != nil {
+		res := r.compare(*lower, *upper)
+		if res > 0 || (res == 0 && (lowerStrict || upperStrict)) {
+			errs[lowerKey] = fmt.Sprintf("Range is empty, value should be less than %s", upperKey)
+		}
+	}
+	return errs
+}
+
+func (r *Range[T]) Conditions() []Condition {
+	var conditions []Condition
+	bounds := []struct {
+		op    Operator
+		value *T
+	}{{OpEq, r.Eq}, {OpGt, r.Gt}, {OpGte, r.Gte}, {OpLt, r.Lt}, {OpLte, r.Lte}}
+	for _, bound := range bounds {
+		if bound.value != nil {
+			conditions = append(conditions, Condition{Field: r.Field, Operator: bound.op, Value: *bound.value})
+		}
+	}
+	return conditions
+}
+
+// NewMovieFilters collects conditions of the ranges into MovieFilters.
+// Returns validation errors of all the ranges if some of them are inconsistent
+func NewMovieFilters(title string, genres []string, ranges ...RangeFilter) (MovieFilters, map[string]string) {
+	movieFilters := MovieFilters{Title: title, Genres: genres}
+	errs := make(map[string]string)
+	for _, r := range ranges {
+		maps.Copy(errs, r.Validate())
+		movieFilters.Conditions = append(movieFilters.Conditions, r.Conditions()...)
+	}
+	return movieFilters, errs
+}
+
+const (
+	IncludeReviews       = "reviews"
+	IncludeRatingSummary = "rating_summary"
+)
+
+// MovieProjectionFields lists movie fields, which can be requested in the sparse fieldset
+var MovieProjectionFields = []string{
+	"id", "title", "year", "runtime", "genres", "version", "user_id", "deleted_at", "average_rating", "reviews_count",
+}
+
+// MovieSortAliases maps short sort names to the movie fields
+var MovieSortAliases = map[string]string{"rating": "AverageRating"}
+
+// ReviewSortAliases maps short sort names to the review fields
+var ReviewSortAliases = map[string]string{"helpful": "HelpfulScore"}
+
+// MovieIncludes lists data, which can be embedded into movies on request
+var MovieIncludes = []string{IncludeReviews, IncludeRatingSummary}
+
+// Projection narrows movie fields loaded from the db and opts into embedded data.
+// Empty Fields means all the fields are loaded
+type Projection struct {
+	Fields  []string
+	Include []string
+}
+
+// NewProjection checks fields and includes against the known ones.
+// Validation errors are keyed by query param names
+func NewProjection(fields []string, includes []string) (Projection, map[string]string) {
+	errs := make(map[string]string)
+	for _, field := range fields {
+		if !slices.Contains(MovieProjectionFields, field) {
+			errs["fields"] = fmt.Sprintf("Unknown field %s, should be one of: %s", field, strings.Join(MovieProjectionFields, ", "))
+			break
+		}
+	}
+	for _, include := range includes {
+		if !slices.Contains(MovieIncludes, include) {
+			errs["include"] = fmt.Sprintf("Unknown include %s, should be one of: %s", include, strings.Join(MovieIncludes, ", "))
+			break
+		}
+	}
+	return Projection{Fields: fields, Include: includes}, errs
+}
+
+// Includes reports whether the data should be embedded into movies
+func (p Projection) Includes(include string) bool {
+	return slices.Contains(p.Include, include)
+}
+
+// HasField reports whether the field is a part of the projection
+func (p Projection) HasField(field string) bool {
+	return len(p.Fields) == 0 || slices.Contains(p.Fields, field)
+}
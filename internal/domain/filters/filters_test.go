@@ -0,0 +1,141 @@
+package filters
+
+import (
+	"cmp"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	key := []byte("secret")
+	t.Run("round trip", func(t *testing.T) {
+		cursor := &Cursor{Sort: "-year", LastID: 42, Value: json.RawMessage("1999"), Direction: CursorNext}
+		decoded, err := DecodeCursor(cursor.Encode(key), key)
+		require.NoError(t, err)
+		assert.Equal(t, cursor, decoded)
+	})
+	t.Run("invalid", func(t *testing.T) {
+		for _, token := range []string{
+			"not a cursor",
+			(&Cursor{Sort: "id", Value: json.RawMessage("1"), Direction: CursorNext}).Encode(key),
+			(&Cursor{LastID: 1, Value: json.RawMessage("1")}).Encode(key),
+			(&Cursor{LastID: 1, Direction: CursorNext}).Encode(key),
+			(&Cursor{LastID: 1, Value: json.RawMessage("1"), Null: true, Direction: CursorNext}).Encode(key),
+			(&Cursor{LastID: 1, Value: json.RawMessage("1"), Direction: CursorNext}).Encode([]byte("another secret")),
+		} {
+			_, err := DecodeCursor(token, key)
+			assert.ErrorIs(t, err, ErrInvalidCursor)
+		}
+	})
+	t.Run("value", func(t *testing.T) {
+		type record struct {
+			ID        int64
+			Year      int32
+			DeletedAt *time.Time
+		}
+		deletedAt := time.Date(2024, 10, 1, 12, 30, 0, 0, time.UTC)
+		testCases := []struct {
+			name          string
+			sort          string
+			record        record
+			expectedNull  bool
+			expectedValue any
+		}{
+			{"value", "-year", record{ID: 1, Year: 1999}, false, int32(1999)},
+			{"nullable value", "deleted_at", record{ID: 2, DeletedAt: &deletedAt}, false, &deletedAt},
+			{"null", "-deleted_at", record{ID: 3}, true, nil},
+		}
+		for _, testCase := range testCases {
+			t.Run(testCase.name, func(t *testing.T) {
+				f := Filters{Sort: testCase.sort, SortSafelist: []string{"ID", "Year", "DeletedAt"}}
+				cursor := f.NewCursor(testCase.record, testCase.record.ID, CursorNext)
+				assert.Equal(t, testCase.expectedNull, cursor.Null)
+				f.Cursor, _ = DecodeCursor(cursor.Encode(key), key)
+				require.NotNil(t, f.Cursor)
+				value, err := f.CursorValue(record{})
+				require.NoError(t, err)
+				if testCase.expectedValue == nil {
+					assert.Nil(t, value)
+				} else {
+					assert.Equal(t, testCase.expectedValue, value)
+				}
+			})
+		}
+	})
+}
+
+func TestKeyset(t *testing.T) {
+	testCases := []struct {
+		name              string
+		sort              string
+		cursor            *Cursor
+		expectedDirection string
+		expectedOperator  string
+	}{
+		{"first page asc", "title", nil, AscSort, ">"},
+		{"first page desc", "-title", nil, DescSort, "<"},
+		{"next page desc", "-title", &Cursor{Direction: CursorNext}, DescSort, "<"},
+		{"prev page asc", "title", &Cursor{Direction: CursorPrev}, DescSort, "<"},
+		{"prev page desc", "-title", &Cursor{Direction: CursorPrev}, AscSort, ">"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			f := Filters{Sort: testCase.sort, Cursor: testCase.cursor}
+			assert.Equal(t, testCase.expectedDirection, f.KeysetDirection())
+			assert.Equal(t, testCase.expectedOperator, f.KeysetOperator())
+		})
+	}
+	t.Run("sort column", func(t *testing.T) {
+		f := Filters{Sort: "-createdat", SortSafelist: []string{"ID", "CreatedAt"}}
+		assert.Equal(t, "created_at", f.SortColumn())
+	})
+	t.Run("sort alias", func(t *testing.T) {
+		f := Filters{Sort: "-rating", SortSafelist: []string{"ID", "AverageRating"}, SortAliases: MovieSortAliases}
+		assert.Equal(t, "average_rating", f.SortColumn())
+	})
+}
+
+func TestRange(t *testing.T) {
+	ptr := func(v int32) *int32 { return &v }
+	testCases := []struct {
+		name         string
+		r            *Range[int32]
+		expectedErrs []string
+		conditionsN  int
+	}{
+		{"exact", NewRange("year", cmp.Compare[int32], ptr(2000), nil, nil, nil, nil), nil, 1},
+		{"bounds", NewRange("year", cmp.Compare[int32], nil, nil, ptr(1990), ptr(2000), nil), nil, 2},
+		{"same inclusive bounds", NewRange("year", cmp.Compare[int32], nil, nil, ptr(2000), nil, ptr(2000)), nil, 2},
+		{"exact with bounds", NewRange("year", cmp.Compare[int32], ptr(2000), ptr(1990), nil, nil, nil), []string{"year"}, 2},
+		{"gt with gte", NewRange("year", cmp.Compare[int32], nil, ptr(1990), ptr(1990), nil, nil), []string{"year_gt"}, 2},
+		{"empty range", NewRange("year", cmp.Compare[int32], nil, ptr(2000), nil, ptr(2000), nil), []string{"year_gt"}, 2},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			errs := testCase.r.Validate()
+			assert.Len(t, errs, len(testCase.expectedErrs))
+			for _, key := range testCase.expectedErrs {
+				assert.Contains(t, errs, key)
+			}
+			assert.Len(t, testCase.r.Conditions(), testCase.conditionsN)
+		})
+	}
+}
+
+func TestProjection(t *testing.T) {
+	projection, errs := NewProjection([]string{"id", "title"}, []string{IncludeReviews})
+	assert.Empty(t, errs)
+	assert.True(t, projection.HasField("title"))
+	assert.False(t, projection.HasField("year"))
+	assert.True(t, projection.Includes(IncludeReviews))
+	assert.False(t, projection.Includes(IncludeRatingSummary))
+	assert.True(t, Projection{}.HasField("year"))
+
+	_, errs = NewProjection([]string{"id", "reviews"}, []string{"revisions"})
+	assert.Contains(t, errs, "fields")
+	assert.Contains(t, errs, "include")
+}
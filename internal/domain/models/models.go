@@ -2,18 +2,94 @@ package models
 
 import (
 	"greenlight/proj/internal/domain/fields"
+	"slices"
 	"time"
 )
 
 type Movie struct {
-	ID        int64               `json:"id"`                // Unique integer ID for the movie
-	Title     string              `json:"title"`             // Movie title
-	Year      int32               `json:"year,omitempty"`    // Movie release year
-	Runtime   fields.MovieRuntime `json:"runtime,omitempty"` // Movie runtime (in minutes)
-	Genres    []string            `json:"genres,omitempty"`  // Movie genres (i.e. Comedy, drama, scifi)
-	Version   uint                `json:"version"`           // The version number starts at 1 and will be incremented each // time the movie information is updated
-	CreatedAt time.Time           `json:"-"`                 // Timestamp for when the movie is added to our database
-	Reviews   []Review            `json:"reviews" db:"-"`    // List of reviews
+	ID            int64               `json:"id"`                              // Unique integer ID for the movie
+	Title         string              `json:"title"`                           // Movie title
+	Year          int32               `json:"year,omitempty"`                  // Movie release year
+	Runtime       fields.MovieRuntime `json:"runtime,omitempty"`               // Movie runtime (in minutes)
+	Genres        []string            `json:"genres,omitempty"`                // Movie genres (i.e. Comedy, drama, scifi)
+	Version       uint                `json:"version"`                         // The version number starts at 1 and will be incremented each // time the movie information is updated
+	CreatedAt     time.Time           `json:"-"`                               // Timestamp for when the movie is added to our database
+	UserID        int64               `json:"user_id"`                         // ID of the user who added the movie
+	DeletedAt     *time.Time          `json:"deleted_at,omitempty"`            // Timestamp for when the movie was moved to the trash
+	AverageRating float64             `json:"average_rating"`                  // Average rating of the movie reviews, 0 if there are no reviews
+	ReviewsCount  int                 `json:"reviews_count"`                   // Number of the movie reviews
+	Reviews       []Review            `json:"reviews" db:"-"`                  // List of reviews
+	RatingSummary *RatingSummary      `json:"rating_summary,omitempty" db:"-"` // Aggregated ratings of the movie reviews
+}
+
+// MovieSearchHit is a movie found by the search alongside with its relevance
+type MovieSearchHit struct {
+	Movie
+	Rank       float64 // Full text search rank, 0 for movies matched by similarity only
+	Similarity float64 // Trigram similarity of the query to the closest words of the title
+	Highlight  string  // HTML escaped title with matched words wrapped in <mark> tags
+}
+
+// RatingSummary aggregates ratings of the movie reviews
+type RatingSummary struct {
+	AverageRating float64 `json:"average_rating"`
+	ReviewsCount  int     `json:"reviews_count"`
+	Histogram     []int   `json:"histogram"` // Number of reviews for each rating from 1 to 5
+}
+
+// Snapshot returns editable fields of the movie
+func (m *Movie) Snapshot() MovieSnapshot {
+	return MovieSnapshot{Title: m.Title, Year: m.Year, Runtime: m.Runtime, Genres: m.Genres}
+}
+
+// MovieSnapshot holds editable fields of the movie as they were at a particular version
+type MovieSnapshot struct {
+	Title   string              `json:"title"`
+	Year    int32               `json:"year"`
+	Runtime fields.MovieRuntime `json:"runtime"`
+	Genres  []string            `json:"genres"`
+}
+
+// DiffMovieSnapshots returns fields which differ between the snapshots.
+// If old is nil, all the fields of the new snapshot are considered changed (e.g. for just created movie)
+func DiffMovieSnapshots(old *MovieSnapshot, new MovieSnapshot) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	if old == nil {
+		changes["title"] = FieldChange{New: new.Title}
+		changes["year"] = FieldChange{New: new.Year}
+		changes["runtime"] = FieldChange{New: new.Runtime}
+		changes["genres"] = FieldChange{New: new.Genres}
+		return changes
+	}
+	if old.Title != new.Title {
+		changes["title"] = FieldChange{Old: old.Title, New: new.Title}
+	}
+	if old.Year != new.Year {
+		changes["year"] = FieldChange{Old: old.Year, New: new.Year}
+	}
+	if old.Runtime != new.Runtime {
+		changes["runtime"] = FieldChange{Old: old.Runtime, New: new.Runtime}
+	}
+	if !slices.Equal(old.Genres, new.Genres) {
+		changes["genres"] = FieldChange{Old: old.Genres, New: new.Genres}
+	}
+	return changes
+}
+
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// MovieRevision is a record of the movie change, which produced the movie version
+type MovieRevision struct {
+	ID        int64                  `json:"id"`
+	MovieID   int64                  `json:"movie_id"`
+	Version   uint                   `json:"version"`
+	UserID    int64                  `json:"user_id"`  // ID of the user who made the change
+	Changes   map[string]FieldChange `json:"changes"`  // Changed fields with their old and new values
+	Snapshot  MovieSnapshot          `json:"snapshot"` // State of the movie after the change
+	CreatedAt time.Time              `json:"created_at"`
 }
 
 var AnonymousUser = &User{}
@@ -33,17 +109,112 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+// SystemUserID is used in the records created by the application itself (e.g. flags raised by the content filter)
+const SystemUserID = 0
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+	ReviewFlagged  = "flagged"
+)
+
 type Review struct {
+	ID               int64      `json:"id"`
+	MovieID          int64      `json:"movie_id"`
+	UserID           int64      `json:"user_id"`
+	Comment          string     `json:"comment"`
+	Rating           int        `json:"rating"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	Status           string     `json:"status"`                      // Moderation status, only approved reviews are public
+	ModerationReason *string    `json:"moderation_reason,omitempty"` // Reason given by the moderator for the last decision
+	ModeratedBy      *int64     `json:"moderated_by,omitempty"`      // ID of the moderator who made the last decision
+	ModeratedAt      *time.Time `json:"moderated_at,omitempty"`
+	HelpfulVotes     int        `json:"helpful_votes"`
+	UnhelpfulVotes   int        `json:"unhelpful_votes"`
+	HelpfulScore     int        `json:"helpful_score"`        // Helpful votes minus unhelpful ones
+	RepliesCount     int        `json:"replies_count"`        // Replies in the thread of the review, except deleted ones
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"` // Set for the tombstone of the deleted review which has replies
+}
+
+// UserReview is a review listed among the user activity alongside with the reviewed movie title
+type UserReview struct {
+	Review
+	MovieTitle string `json:"movie_title"`
+}
+
+// ReviewFlag is a complaint of the user about the review
+type ReviewFlag struct {
 	ID        int64     `json:"id"`
-	MovieID   int64     `json:"movie_id"`
+	ReviewID  int64     `json:"review_id"`
 	UserID    int64     `json:"user_id"`
-	Comment   string    `json:"comment"`
-	Rating    int       `json:"rating"`
-	CreatedAt time.Time `json:"-"`
-	UpdatedAt time.Time `json:"-"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReviewReply is a reply in the thread under the review. Replies to the review itself have no parent.
+// Deleted replies which have replies of their own are kept as tombstones with empty body
+type ReviewReply struct {
+	ID           int64      `json:"id"`
+	ReviewID     int64      `json:"review_id"`
+	ParentID     *int64     `json:"parent_id"`
+	UserID       int64      `json:"user_id"`
+	Body         string     `json:"body"`
+	Depth        int        `json:"depth"`         // 0 for replies to the review itself
+	RepliesCount int        `json:"replies_count"` // Direct replies, including tombstones
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	DeletedAt    *time.Time `json:"deleted_at,omitempty"`
+}
+
+// ModerationQueueItem is a review awaiting moderation with the number of unresolved flags
+type ModerationQueueItem struct {
+	Review
+	FlagsCount int `json:"flags_count"`
 }
 
 type AuthTokens struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
 }
+
+// AccessToken describes the access token of the authenticated request, so it can be revoked
+type AccessToken struct {
+	ID        string // jti claim of the token, or its hash if SSO hasn't set the claim
+	UserID    int64
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// RefreshToken is the refresh token issued by the app alongside with the state of its family,
+// i.e. of all the tokens rotated since the same login
+type RefreshToken struct {
+	Hash                  []byte
+	FamilyID              int64
+	UserID                int64
+	SealedSsoRefreshToken []byte `db:"sso_refresh_token"` // Encrypted refresh token issued by SSO on login, it's used to renew access tokens
+	ExpiresAt             time.Time
+	RotatedAt             *time.Time // Set once the token is exchanged, reuse of the rotated token means it has leaked
+	RevokedAt             *time.Time
+}
+
+// ApiKey authenticates machine clients on behalf of the user. The key is limited to its scopes,
+// which are a subset of the permissions of the user at the time of creation
+type ApiKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the key, so the user can tell the keys apart
+	Hash       []byte     `json:"-"`
+	Scopes     []string   `json:"scopes"` // Permission codes granted to the key
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// HasScope reports whether the key is granted the permission
+func (k *ApiKey) HasScope(permissionCode string) bool {
+	return slices.Contains(k.Scopes, permissionCode)
+}
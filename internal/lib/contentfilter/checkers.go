@@ -0,0 +1,149 @@
+package contentfilter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ProfanityChecker looks for the listed words. Words are matched as whole words ignoring case
+type ProfanityChecker struct {
+	Rules ProfanityRules
+	re    *regexp.Regexp
+}
+
+func NewProfanityChecker(rules ProfanityRules) *ProfanityChecker {
+	words := make([]string, 0, len(rules.Words))
+	for _, word := range rules.Words {
+		words = append(words, regexp.QuoteMeta(word))
+	}
+	return &ProfanityChecker{Rules: rules, re: regexp.MustCompile(`(?i)\b(?:` + strings.Join(words, "|") + `)\b`)}
+}
+
+func (c *ProfanityChecker) Check(submission Submission) Result {
+	if !c.re.MatchString(submission.Text) {
+		return Result{Action: Allow}
+	}
+	result := Result{Action: c.Rules.Action, Reason: "text contains profanity"}
+	if c.Rules.Action == Mask {
+		result.Text = c.re.ReplaceAllStringFunc(submission.Text, func(word string) string {
+			return strings.Repeat("*", utf8.RuneCountInString(word))
+		})
+	}
+	return result
+}
+
+var linkRe = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// LinksChecker limits the number of links in the text
+type LinksChecker struct {
+	Rules LinksRules
+}
+
+func (c *LinksChecker) Check(submission Submission) Result {
+	links := linkRe.FindAllStringIndex(submission.Text, -1)
+	if len(links) <= c.Rules.MaxLinks {
+		return Result{Action: Allow}
+	}
+	result := Result{Action: c.Rules.Action, Reason: fmt.Sprintf("text contains more than %d links", c.Rules.MaxLinks)}
+	if c.Rules.Action == Mask {
+		result.Text = linkRe.ReplaceAllString(submission.Text, "[link removed]")
+	}
+	return result
+}
+
+// SpamChecker detects shouting (too many uppercase letters) and long runs of the same character
+type SpamChecker struct {
+	Rules SpamRules
+}
+
+func (c *SpamChecker) Check(submission Submission) Result {
+	var letters, upper, run, maxRun int
+	var prev rune
+	for _, r := range submission.Text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+		if r == prev {
+			run++
+		} else {
+			run = 1
+		}
+		maxRun = max(maxRun, run)
+		prev = r
+	}
+	if c.Rules.MaxRepeatedChars > 0 && maxRun > c.Rules.MaxRepeatedChars {
+		return Result{Action: c.Rules.Action, Reason: "text contains long runs of the same character"}
+	}
+	if c.Rules.MaxUppercaseRatio > 0 && letters >= c.Rules.MinLength && letters > 0 &&
+		float64(upper)/float64(letters) > c.Rules.MaxUppercaseRatio {
+		return Result{Action: c.Rules.Action, Reason: "text contains too many uppercase letters"}
+	}
+	return Result{Action: Allow}
+}
+
+// RepeatedTextChecker detects texts repeating the same words and texts the user has already submitted recently
+type RepeatedTextChecker struct {
+	Rules   RepeatedTextRules
+	tracker *recentTracker
+}
+
+func (c *RepeatedTextChecker) Check(submission Submission) Result {
+	words := textWords(submission.Text)
+	if c.Rules.DuplicateWindow > 0 && len(words) > 0 &&
+		c.tracker.seen(submission.UserID, normalizeText(submission.Text), c.Rules.DuplicateWindow) {
+		return Result{Action: c.Rules.Action, Reason: "same text has been submitted recently"}
+	}
+	if c.Rules.MinUniqueRatio > 0 && len(words) >= c.Rules.MinWords && len(words) > 0 {
+		unique := make(map[string]struct{}, len(words))
+		for _, word := range words {
+			unique[word] = struct{}{}
+		}
+		if float64(len(unique))/float64(len(words)) < c.Rules.MinUniqueRatio {
+			return Result{Action: c.Rules.Action, Reason: "text repeats the same words"}
+		}
+	}
+	return Result{Action: Allow}
+}
+
+// Record remembers the saved text, so the same text of the user is detected within the window
+func (c *RepeatedTextChecker) Record(submission Submission) {
+	if c.Rules.DuplicateWindow > 0 && len(textWords(submission.Text)) > 0 {
+		c.tracker.remember(submission.UserID, normalizeText(submission.Text), c.Rules.DuplicateWindow)
+	}
+}
+
+// textWords splits the text into lowercase words ignoring punctuation
+func textWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalizeText makes texts differing in case and punctuation only equal
+func normalizeText(text string) string {
+	return strings.Join(textWords(text), " ")
+}
+
+// RateChecker limits the number of submissions of the user within the window
+type RateChecker struct {
+	Rules   RateRules
+	tracker *rateTracker
+}
+
+func (c *RateChecker) Check(submission Submission) Result {
+	if c.tracker.allow(submission.UserID, c.Rules.Limit, c.Rules.Window) {
+		return Result{Action: Allow}
+	}
+	return Result{Action: c.Rules.Action, Reason: fmt.Sprintf("more than %d submissions within %s", c.Rules.Limit, c.Rules.Window)}
+}
+
+// Record counts the saved submission of the user
+func (c *RateChecker) Record(submission Submission) {
+	c.tracker.record(submission.UserID, c.Rules.Window)
+}
@@ -0,0 +1,255 @@
+// Package contentfilter checks user submitted text (e.g. review comments) with a chain of configurable checkers.
+// Each checker can allow the text, mask parts of it, flag it for moderation or reject it
+package contentfilter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// Action is a decision of the checker about the text
+type Action string
+
+const (
+	Allow  Action = "allow"
+	Mask   Action = "mask"
+	Flag   Action = "flag"
+	Reject Action = "reject"
+)
+
+var ErrInvalidRules = errors.New("invalid content filter rules")
+
+// severity orders actions, so the most severe one of the pipeline becomes the verdict
+func (a Action) severity() int {
+	switch a {
+	case Mask:
+		return 1
+	case Flag:
+		return 2
+	case Reject:
+		return 3
+	}
+	return 0
+}
+
+type Submission struct {
+	UserID int64
+	Text   string
+}
+
+// Result of the single check. Text is set for the Mask action only
+type Result struct {
+	Action Action
+	Reason string
+	Text   string
+}
+
+type Checker interface {
+	Check(submission Submission) Result
+}
+
+// Recorder is implemented by the stateful checkers. Check doesn't change their state,
+// instead submissions are recorded once they are actually saved, so rejected or failed ones don't count
+type Recorder interface {
+	Record(submission Submission)
+}
+
+// Verdict is the result of the whole pipeline. Text has all the masks applied
+type Verdict struct {
+	Action  Action
+	Text    string
+	Reasons []string
+}
+
+// Pipeline runs checkers in order. Masked text is passed to the following checkers and
+// the first rejection stops the pipeline
+type Pipeline []Checker
+
+func (p Pipeline) Run(submission Submission) Verdict {
+	verdict := Verdict{Action: Allow, Text: submission.Text}
+	for _, checker := range p {
+		result := checker.Check(Submission{UserID: submission.UserID, Text: verdict.Text})
+		if result.Action == Allow || result.Action == "" {
+			continue
+		}
+		verdict.Reasons = append(verdict.Reasons, result.Reason)
+		if result.Action == Mask {
+			verdict.Text = result.Text
+		}
+		if result.Action.severity() > verdict.Action.severity() {
+			verdict.Action = result.Action
+		}
+		if result.Action == Reject {
+			break
+		}
+	}
+	return verdict
+}
+
+// Record passes the saved submission to the stateful checkers
+func (p Pipeline) Record(submission Submission) {
+	for _, checker := range p {
+		if recorder, ok := checker.(Recorder); ok {
+			recorder.Record(submission)
+		}
+	}
+}
+
+// Filter runs the pipeline built from the rules file. Rules can be reloaded while the filter is in use.
+// State of the stateful checkers (submission rates and recent texts) is kept between reloads
+type Filter struct {
+	path     string
+	modTime  time.Time
+	pipeline atomic.Pointer[Pipeline]
+	rates    *rateTracker
+	recent   *recentTracker
+	mu       sync.Mutex // serializes reloads
+}
+
+// New loads the rules from the file. Filter with empty path allows any text
+func New(path string) (*Filter, error) {
+	f := &Filter{path: path, rates: newRateTracker(time.Now), recent: newRecentTracker(time.Now)}
+	f.pipeline.Store(&Pipeline{})
+	if path == "" {
+		return f, nil
+	}
+	if _, err := f.ReloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *Filter) Check(userID int64, text string) Verdict {
+	return f.pipeline.Load().Run(Submission{UserID: userID, Text: text})
+}
+
+// Record should be called with the text from the verdict once it's saved
+func (f *Filter) Record(userID int64, text string) {
+	f.pipeline.Load().Record(Submission{UserID: userID, Text: text})
+}
+
+// ReloadIfChanged rebuilds the pipeline if the rules file was modified since the last load.
+// Invalid rules are reported as error and the current pipeline is kept
+func (f *Filter) ReloadIfChanged() (reloaded bool, err error) {
+	if f.path == "" {
+		return false, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(f.modTime) {
+		return false, nil
+	}
+	var rules Rules
+	if err := cleanenv.ReadConfig(f.path, &rules); err != nil {
+		return false, err
+	}
+	pipeline, err := newPipeline(&rules, f.rates, f.recent)
+	if err != nil {
+		return false, err
+	}
+	f.pipeline.Store(&pipeline)
+	f.modTime = info.ModTime()
+	return true, nil
+}
+
+// Rules configure the checkers. Checker is disabled if its action is empty
+type Rules struct {
+	Profanity    ProfanityRules    `yaml:"profanity"`
+	Links        LinksRules        `yaml:"links"`
+	Spam         SpamRules         `yaml:"spam"`
+	RepeatedText RepeatedTextRules `yaml:"repeated_text"`
+	Rate         RateRules         `yaml:"rate"`
+}
+
+type ProfanityRules struct {
+	Action Action   `yaml:"action"`
+	Words  []string `yaml:"words"`
+}
+
+type LinksRules struct {
+	Action   Action `yaml:"action"`
+	MaxLinks int    `yaml:"max_links"`
+}
+
+type SpamRules struct {
+	Action            Action  `yaml:"action"`
+	MinLength         int     `yaml:"min_length"`          // Uppercase ratio is checked for texts with at least that many letters
+	MaxUppercaseRatio float64 `yaml:"max_uppercase_ratio"` // Disabled if 0
+	MaxRepeatedChars  int     `yaml:"max_repeated_chars"`  // Disabled if 0
+}
+
+type RepeatedTextRules struct {
+	Action          Action        `yaml:"action"`
+	MinWords        int           `yaml:"min_words"`        // Words variety is checked for texts with at least that many words
+	MinUniqueRatio  float64       `yaml:"min_unique_ratio"` // Disabled if 0
+	DuplicateWindow time.Duration `yaml:"duplicate_window"` // Same text of the user within the window is a duplicate. Disabled if 0
+}
+
+type RateRules struct {
+	Action Action        `yaml:"action"`
+	Limit  int           `yaml:"limit"`
+	Window time.Duration `yaml:"window"`
+}
+
+// newPipeline builds checkers from the rules in the order they are listed in Rules, so the cheapest ones run first.
+// Stateful checkers share the trackers, so their state outlives the pipeline
+func newPipeline(rules *Rules, rates *rateTracker, recent *recentTracker) (Pipeline, error) {
+	var pipeline Pipeline
+	if err := validateAction(rules.Rate.Action, "rate", false); err != nil {
+		return nil, err
+	}
+	if rules.Rate.Action != "" {
+		if rules.Rate.Limit < 1 || rules.Rate.Window <= 0 {
+			return nil, fmt.Errorf("%w: rate limit and window must be positive", ErrInvalidRules)
+		}
+		pipeline = append(pipeline, &RateChecker{Rules: rules.Rate, tracker: rates})
+	}
+	if err := validateAction(rules.Profanity.Action, "profanity", true); err != nil {
+		return nil, err
+	}
+	if rules.Profanity.Action != "" && len(rules.Profanity.Words) > 0 {
+		pipeline = append(pipeline, NewProfanityChecker(rules.Profanity))
+	}
+	if err := validateAction(rules.Links.Action, "links", true); err != nil {
+		return nil, err
+	}
+	if rules.Links.Action != "" {
+		pipeline = append(pipeline, &LinksChecker{Rules: rules.Links})
+	}
+	if err := validateAction(rules.Spam.Action, "spam", false); err != nil {
+		return nil, err
+	}
+	if rules.Spam.Action != "" {
+		pipeline = append(pipeline, &SpamChecker{Rules: rules.Spam})
+	}
+	if err := validateAction(rules.RepeatedText.Action, "repeated_text", false); err != nil {
+		return nil, err
+	}
+	if rules.RepeatedText.Action != "" {
+		pipeline = append(pipeline, &RepeatedTextChecker{Rules: rules.RepeatedText, tracker: recent})
+	}
+	return pipeline, nil
+}
+
+// validateAction checks that the action is known. Masking is supported by some checkers only
+func validateAction(action Action, checker string, canMask bool) error {
+	switch action {
+	case "", Flag, Reject:
+		return nil
+	case Mask:
+		if canMask {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: unsupported %s action %q", ErrInvalidRules, checker, action)
+}
@@ -0,0 +1,98 @@
+package contentfilter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPipeline(t *testing.T) {
+	rules := &Rules{
+		Profanity:    ProfanityRules{Action: Mask, Words: []string{"darn"}},
+		Links:        LinksRules{Action: Flag},
+		Spam:         SpamRules{Action: Reject, MinLength: 10, MaxUppercaseRatio: 0.7, MaxRepeatedChars: 5},
+		RepeatedText: RepeatedTextRules{Action: Reject, MinWords: 5, MinUniqueRatio: 0.4},
+	}
+	pipeline, err := newPipeline(rules, newRateTracker(time.Now), newRecentTracker(time.Now))
+	require.NoError(t, err)
+	testCases := []struct {
+		name           string
+		text           string
+		expectedAction Action
+		expectedText   string
+	}{
+		{"clean", "Great movie, loved the ending", Allow, "Great movie, loved the ending"},
+		{"masked", "Darn good movie", Mask, "**** good movie"},
+		{"masked and flagged", "darn, see www.example.com", Flag, "****, see www.example.com"},
+		{"shouting", "THIS MOVIE IS THE BEST EVER", Reject, "THIS MOVIE IS THE BEST EVER"},
+		{"repeated chars", "Good!!!!!!!!", Reject, "Good!!!!!!!!"},
+		{"repeated words", "buy buy buy buy buy now", Reject, "buy buy buy buy buy now"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			verdict := pipeline.Run(Submission{UserID: 1, Text: testCase.text})
+			assert.Equal(t, testCase.expectedAction, verdict.Action)
+			assert.Equal(t, testCase.expectedText, verdict.Text)
+			assert.Equal(t, testCase.expectedAction == Allow, len(verdict.Reasons) == 0)
+		})
+	}
+}
+
+func TestStatefulCheckers(t *testing.T) {
+	now := time.Date(2024, 10, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	t.Run("rate", func(t *testing.T) {
+		checker := &RateChecker{Rules: RateRules{Action: Reject, Limit: 2, Window: time.Minute}, tracker: newRateTracker(clock)}
+		submit := func(userID int64) Action {
+			action := checker.Check(Submission{UserID: userID}).Action
+			if action == Allow {
+				checker.Record(Submission{UserID: userID})
+			}
+			return action
+		}
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 1}).Action)
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 1}).Action)
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 1}).Action, "checks alone must not count")
+		assert.Equal(t, Allow, submit(1))
+		assert.Equal(t, Allow, submit(1))
+		assert.Equal(t, Reject, submit(1))
+		assert.Equal(t, Allow, submit(2))
+		now = now.Add(time.Minute)
+		assert.Equal(t, Allow, submit(1))
+	})
+	t.Run("duplicates", func(t *testing.T) {
+		checker := &RepeatedTextChecker{Rules: RepeatedTextRules{Action: Flag, DuplicateWindow: time.Hour}, tracker: newRecentTracker(clock)}
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 1, Text: "Nice movie"}).Action)
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 1, Text: "Nice movie"}).Action, "checks alone must not be remembered")
+		checker.Record(Submission{UserID: 1, Text: "Nice movie"})
+		assert.Equal(t, Flag, checker.Check(Submission{UserID: 1, Text: "nice movie!"}).Action)
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 2, Text: "Nice movie"}).Action)
+		now = now.Add(2 * time.Hour)
+		assert.Equal(t, Allow, checker.Check(Submission{UserID: 1, Text: "Nice movie"}).Action)
+	})
+}
+
+func TestFilterReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("profanity:\n  action: reject\n  words: [darn]\n"), 0o600))
+	filter, err := New(path)
+	require.NoError(t, err)
+	assert.Equal(t, Reject, filter.Check(1, "darn").Action)
+
+	require.NoError(t, os.WriteFile(path, []byte("profanity:\n  action: shout\n  words: [darn]\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	_, err = filter.ReloadIfChanged()
+	assert.ErrorIs(t, err, ErrInvalidRules)
+	assert.Equal(t, Reject, filter.Check(1, "darn").Action, "invalid rules must not replace the current ones")
+
+	require.NoError(t, os.WriteFile(path, []byte("profanity:\n  action: mask\n  words: [darn]\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Second)))
+	reloaded, err := filter.ReloadIfChanged()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, Mask, filter.Check(1, "darn").Action)
+}
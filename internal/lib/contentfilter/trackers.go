@@ -0,0 +1,134 @@
+package contentfilter
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+// maxRecentTexts limits the number of texts remembered per user
+const maxRecentTexts = 20
+
+// rateTracker keeps submission times of the users within the sliding window.
+// State is kept in memory, so the limit applies per application instance
+type rateTracker struct {
+	mu          sync.Mutex
+	now         func() time.Time
+	submissions map[int64][]time.Time
+	lastSweep   time.Time
+}
+
+func newRateTracker(now func() time.Time) *rateTracker {
+	return &rateTracker{now: now, submissions: make(map[int64][]time.Time)}
+}
+
+// allow reports whether the user has made less than limit submissions within the window
+func (t *rateTracker) allow(userID int64, limit int, window time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now, window)
+	times := dropExpired(t.submissions[userID], now.Add(-window))
+	t.submissions[userID] = times
+	return len(times) < limit
+}
+
+// record remembers the submission of the user
+func (t *rateTracker) record(userID int64, window time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	t.sweep(now, window)
+	t.submissions[userID] = append(dropExpired(t.submissions[userID], now.Add(-window)), now)
+}
+
+// sweep forgets users without submissions within the window. It runs at most once per window
+func (t *rateTracker) sweep(now time.Time, window time.Duration) {
+	if now.Sub(t.lastSweep) < window {
+		return
+	}
+	for userID, times := range t.submissions {
+		if len(times) == 0 || !times[len(times)-1].After(now.Add(-window)) {
+			delete(t.submissions, userID)
+		}
+	}
+	t.lastSweep = now
+}
+
+// dropExpired drops times which aren't after the window start
+func dropExpired(times []time.Time, windowStart time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(windowStart) {
+		i++
+	}
+	return times[i:]
+}
+
+type recentText struct {
+	hash uint64
+	at   time.Time
+}
+
+// recentTracker remembers hashes of the texts recently submitted by the users
+type recentTracker struct {
+	mu        sync.Mutex
+	now       func() time.Time
+	texts     map[int64][]recentText
+	lastSweep time.Time
+}
+
+func newRecentTracker(now func() time.Time) *recentTracker {
+	return &recentTracker{now: now, texts: make(map[int64][]recentText)}
+}
+
+// seen reports whether the user has submitted the text within the window
+func (t *recentTracker) seen(userID int64, text string, window time.Duration) bool {
+	hash := textHash(text)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, recent := range t.recent(userID, window) {
+		if recent.hash == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// remember records the text submitted by the user
+func (t *recentTracker) remember(userID int64, text string, window time.Duration) {
+	hash := textHash(text)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	texts := append(t.recent(userID, window), recentText{hash: hash, at: t.now()})
+	if len(texts) > maxRecentTexts {
+		texts = texts[len(texts)-maxRecentTexts:]
+	}
+	t.texts[userID] = texts
+}
+
+// recent drops texts submitted before the window and returns the remaining texts of the user.
+// Users without texts within the window are forgotten at most once per window. Must be called with mu held
+func (t *recentTracker) recent(userID int64, window time.Duration) []recentText {
+	now := t.now()
+	if now.Sub(t.lastSweep) >= window {
+		for id, texts := range t.texts {
+			if len(texts) == 0 || !texts[len(texts)-1].at.After(now.Add(-window)) {
+				delete(t.texts, id)
+			}
+		}
+		t.lastSweep = now
+	}
+	texts := t.texts[userID]
+	i := 0
+	for i < len(texts) && !texts[i].at.After(now.Add(-window)) {
+		i++
+	}
+	t.texts[userID] = texts[i:]
+	return texts[i:]
+}
+
+func textHash(text string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(text))
+	return h.Sum64()
+}
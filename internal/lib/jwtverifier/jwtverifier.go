@@ -0,0 +1,160 @@
+// Package jwtverifier verifies access tokens locally: signature with one of the keys identified by kid,
+// signing algorithm, expiry, issuer and audience. Tokens signed with unknown keys can be passed to the fallback, e.g. SSO
+package jwtverifier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrUnknownKey   = errors.New("token is signed with unknown key")
+	ErrInvalidKey   = errors.New("invalid verification key")
+)
+
+// Key verifies signatures of the tokens with the kid header equal to ID. Key is []byte for HMAC algorithms
+// and the public key for the asymmetric ones. Every key is bound to the single algorithm, so the token
+// can't make the verifier use e.g. the public RSA key as the HMAC secret
+type Key struct {
+	ID        string
+	Algorithm string
+	Key       any
+}
+
+// Fallback verifies tokens the verifier has no key for
+type Fallback interface {
+	VerifyToken(ctx context.Context, token string) (bool, error)
+}
+
+type Options struct {
+	Algorithms []string // Allowed signing algorithms, keys with other algorithms are rejected
+	Issuer     string   // Not checked if empty
+	Audience   string   // Not checked if empty
+	Leeway     time.Duration
+	DefaultKey string // ID of the key for the tokens without kid header. If empty, such tokens are signed with unknown key
+	Fallback   Fallback
+}
+
+type Verifier struct {
+	keys    map[string]Key
+	options Options
+}
+
+func New(keys []Key, options Options) (*Verifier, error) {
+	v := &Verifier{keys: make(map[string]Key, len(keys)), options: options}
+	for _, key := range keys {
+		if !slices.Contains(options.Algorithms, key.Algorithm) {
+			return nil, fmt.Errorf("%w: algorithm %s of key %q isn't allowed", ErrInvalidKey, key.Algorithm, key.ID)
+		}
+		if _, exists := v.keys[key.ID]; exists {
+			return nil, fmt.Errorf("%w: duplicate key %q", ErrInvalidKey, key.ID)
+		}
+		v.keys[key.ID] = key
+	}
+	if options.DefaultKey != "" {
+		if _, exists := v.keys[options.DefaultKey]; !exists {
+			return nil, fmt.Errorf("%w: default key %q not found", ErrInvalidKey, options.DefaultKey)
+		}
+	}
+	return v, nil
+}
+
+// Verify returns claims of the valid token. Tokens signed with unknown keys are checked by the fallback, if it's set.
+// Errors of the fallback are returned as is, other errors wrap ErrInvalidToken or ErrUnknownKey
+func (v *Verifier) Verify(ctx context.Context, token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, v.keyFunc, v.parserOptions()...)
+	if err == nil {
+		return claims, nil
+	}
+	if !errors.Is(err, ErrUnknownKey) {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if v.options.Fallback == nil {
+		return nil, err
+	}
+	valid, err := v.options.Fallback.VerifyToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if !valid {
+		return nil, fmt.Errorf("%w: rejected by fallback", ErrInvalidToken)
+	}
+	// signature has been checked by the fallback, while the rest of claims are validated the same way
+	claims = jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if err := jwt.NewValidator(v.parserOptions()...).Validate(claims); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	return claims, nil
+}
+
+func (v *Verifier) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		kid = v.options.DefaultKey
+	}
+	key, exists := v.keys[kid]
+	if !exists {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("key %q is used with %s, not %s", key.ID, key.Algorithm, token.Method.Alg())
+	}
+	return key.Key, nil
+}
+
+func (v *Verifier) parserOptions() []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(v.options.Algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(v.options.Leeway),
+	}
+	if v.options.Issuer != "" {
+		options = append(options, jwt.WithIssuer(v.options.Issuer))
+	}
+	if v.options.Audience != "" {
+		options = append(options, jwt.WithAudience(v.options.Audience))
+	}
+	return options
+}
+
+// LoadKey makes the key for the algorithm. HMAC keys use the secret, asymmetric keys are read from the PEM file
+func LoadKey(id string, algorithm string, secret string, publicKeyFile string) (Key, error) {
+	key := Key{ID: id, Algorithm: algorithm}
+	if strings.HasPrefix(algorithm, "HS") {
+		if secret == "" {
+			return Key{}, fmt.Errorf("%w: key %q has no secret", ErrInvalidKey, id)
+		}
+		key.Key = []byte(secret)
+		return key, nil
+	}
+	pem, err := os.ReadFile(publicKeyFile)
+	if err != nil {
+		return Key{}, fmt.Errorf("%w: key %q: %w", ErrInvalidKey, id, err)
+	}
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		key.Key, err = jwt.ParseRSAPublicKeyFromPEM(pem)
+	case strings.HasPrefix(algorithm, "ES"):
+		key.Key, err = jwt.ParseECPublicKeyFromPEM(pem)
+	case algorithm == "EdDSA":
+		key.Key, err = jwt.ParseEdPublicKeyFromPEM(pem)
+	default:
+		return Key{}, fmt.Errorf("%w: key %q has unsupported algorithm %s", ErrInvalidKey, id, algorithm)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("%w: key %q: %w", ErrInvalidKey, id, err)
+	}
+	return key, nil
+}
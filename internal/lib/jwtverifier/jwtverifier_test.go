@@ -0,0 +1,163 @@
+package jwtverifier
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fallbackStub struct {
+	valid bool
+	calls int
+}
+
+func (f *fallbackStub) VerifyToken(ctx context.Context, token string) (bool, error) {
+	f.calls++
+	return f.valid, nil
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"uid": 1,
+		"iss": "sso",
+		"aud": "greenlight",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	keys := []Key{
+		{ID: "old", Algorithm: "HS256", Key: []byte("old secret")},
+		{ID: "new", Algorithm: "HS256", Key: []byte("new secret")},
+		{ID: "rsa", Algorithm: "RS256", Key: &rsaKey.PublicKey},
+		{ID: "ec", Algorithm: "ES256", Key: &ecKey.PublicKey},
+	}
+	verifier, err := New(keys, Options{
+		Algorithms: []string{"HS256", "RS256", "ES256"},
+		Issuer:     "sso",
+		Audience:   "greenlight",
+		DefaultKey: "old",
+	})
+	require.NoError(t, err)
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Hour).Unix()
+	wrongIssuer := validClaims()
+	wrongIssuer["iss"] = "someone"
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "other"
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{name: "Rotated key", token: sign(t, jwt.SigningMethodHS256, "old", []byte("old secret"), validClaims())},
+		{name: "Current key", token: sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), validClaims())},
+		{name: "Default key", token: sign(t, jwt.SigningMethodHS256, "", []byte("old secret"), validClaims())},
+		{name: "RSA key", token: sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims())},
+		{name: "ECDSA key", token: sign(t, jwt.SigningMethodES256, "ec", ecKey, validClaims())},
+		{name: "Wrong secret", token: sign(t, jwt.SigningMethodHS256, "new", []byte("old secret"), validClaims()), wantErr: ErrInvalidToken},
+		{name: "Unknown kid", token: sign(t, jwt.SigningMethodHS256, "unknown", []byte("new secret"), validClaims()), wantErr: ErrUnknownKey},
+		{name: "Algorithm not allowed", token: sign(t, jwt.SigningMethodHS512, "new", []byte("new secret"), validClaims()), wantErr: ErrInvalidToken},
+		{
+			name:    "Public key used as HMAC secret",
+			token:   sign(t, jwt.SigningMethodHS256, "rsa", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), validClaims()),
+			wantErr: ErrInvalidToken,
+		},
+		{name: "Expired", token: sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), expired), wantErr: ErrInvalidToken},
+		{name: "No expiry", token: sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), noExpiry), wantErr: ErrInvalidToken},
+		{name: "Wrong issuer", token: sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), wrongIssuer), wantErr: ErrInvalidToken},
+		{name: "Wrong audience", token: sign(t, jwt.SigningMethodHS256, "new", []byte("new secret"), wrongAudience), wantErr: ErrInvalidToken},
+		{name: "Malformed", token: "not a token", wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifier.Verify(context.Background(), tt.token)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, float64(1), claims["uid"])
+		})
+	}
+}
+
+func TestVerifyFallback(t *testing.T) {
+	options := Options{Algorithms: []string{"HS256"}, Issuer: "sso"}
+	verifier, err := New([]Key{{ID: "local", Algorithm: "HS256", Key: []byte("secret")}}, options)
+	require.NoError(t, err)
+	fallback := &fallbackStub{valid: true}
+	verifier.options.Fallback = fallback
+
+	t.Run("Unknown key is verified by fallback", func(t *testing.T) {
+		_, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "", []byte("sso secret"), validClaims()))
+		require.NoError(t, err)
+		assert.Equal(t, 1, fallback.calls)
+	})
+	t.Run("Claims are validated after fallback", func(t *testing.T) {
+		claims := validClaims()
+		claims["iss"] = "someone"
+		_, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "", []byte("sso secret"), claims))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+	t.Run("Invalid signature of known key isn't passed to fallback", func(t *testing.T) {
+		calls := fallback.calls
+		_, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "local", []byte("wrong"), validClaims()))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		assert.Equal(t, calls, fallback.calls)
+	})
+	t.Run("Rejected by fallback", func(t *testing.T) {
+		fallback.valid = false
+		_, err := verifier.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "", []byte("sso secret"), validClaims()))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestLoadKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "rsa.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+
+	key, err := LoadKey("rsa", "RS256", "", path)
+	require.NoError(t, err)
+	assert.Equal(t, &rsaKey.PublicKey, key.Key)
+
+	_, err = LoadKey("ec", "ES256", "", path)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = LoadKey("hmac", "HS256", "", "")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	_, err = New([]Key{{ID: "hmac", Algorithm: "HS512", Key: []byte("secret")}}, Options{Algorithms: []string{"HS256"}})
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
@@ -0,0 +1,58 @@
+package lru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	t.Run("Least recently used entry is evicted", func(t *testing.T) {
+		cache := New[string, int](2)
+		cache.Set("a", 1, time.Minute)
+		cache.Set("b", 2, time.Minute)
+		_, _ = cache.Get("a")
+		cache.Set("c", 3, time.Minute)
+		_, exists := cache.Get("b")
+		assert.False(t, exists)
+		value, exists := cache.Get("a")
+		assert.True(t, exists)
+		assert.Equal(t, 1, value)
+		assert.Equal(t, 2, cache.Len())
+	})
+	t.Run("Entries expire", func(t *testing.T) {
+		now := time.Now()
+		cache := New[string, int](2)
+		cache.now = func() time.Time { return now }
+		cache.Set("short", 1, time.Second)
+		cache.Set("long", 2, time.Hour)
+		now = now.Add(time.Minute)
+		_, exists := cache.Get("short")
+		assert.False(t, exists)
+		_, exists = cache.Get("long")
+		assert.True(t, exists)
+		assert.Equal(t, 1, cache.Len())
+	})
+	t.Run("Set overwrites the entry", func(t *testing.T) {
+		cache := New[string, int](1)
+		cache.Set("a", 1, time.Minute)
+		cache.Set("a", 2, time.Minute)
+		value, _ := cache.Get("a")
+		assert.Equal(t, 2, value)
+		assert.Equal(t, 1, cache.Len())
+	})
+	t.Run("Delete", func(t *testing.T) {
+		cache := New[int, string](10)
+		for i := range 5 {
+			cache.Set(i, "value", time.Minute)
+		}
+		cache.Delete(0)
+		cache.DeleteFunc(func(key int, value string) bool { return key%2 == 1 })
+		assert.Equal(t, 2, cache.Len())
+		_, exists := cache.Get(2)
+		assert.True(t, exists)
+		_, exists = cache.Get(3)
+		assert.False(t, exists)
+	})
+}
@@ -3,9 +3,11 @@ package validator
 import (
 	"errors"
 	"fmt"
+	"greenlight/proj/internal/domain/filters"
 	"greenlight/proj/internal/domain/models"
 	"greenlight/proj/internal/utils"
 	"reflect"
+	"regexp"
 	"strings"
 
 	govalidator "github.com/go-playground/validator/v10"
@@ -64,7 +66,7 @@ func GetErrorMsgForField(obj any, err govalidator.FieldError) (errorMsg string)
 	errorMsg = field.Tag.Get("errorMsg")
 	if errorMsg == "" {
 		switch err.Tag() {
-		case "required":
+		case "required", "required_if":
 			errorMsg = "This field is required"
 		case "max":
 			errorMsg = fmt.Sprintf("The maximum value is %s", err.Param())
@@ -98,6 +100,10 @@ func GetErrorMsgForField(obj any, err govalidator.FieldError) (errorMsg string)
 			errorMsg = "Value must be alphanumeric"
 		case "sortbymoviefield":
 			errorMsg = "Value must be a name of one of the movie fields (e.g. +title, -year, etc...)"
+		case "sortbyreviewfield":
+			errorMsg = "Value must be a name of one of the review fields (e.g. +rating, -created_at, etc...)"
+		case "permissioncode":
+			errorMsg = "Value must be a permission code in the form of resource:action (e.g. movies:read)"
 		default:
 			errorMsg = "This field is invalid"
 		}
@@ -108,12 +114,30 @@ func GetErrorMsgForField(obj any, err govalidator.FieldError) (errorMsg string)
 // CUSTOM VALIDATORS
 
 func ValidateSortByMovieField(fl govalidator.FieldLevel) bool {
-	sort := fl.Field().String()
-	t := reflect.TypeOf(models.Movie{})
-	sort = strings.TrimPrefix(sort, "-")
-	fieldName := strings.ToUpper(string(sort[0])) + sort[1:]
-	fmt.Println(fieldName)
-	if _, ok := t.FieldByNameFunc(func(s string) bool { return strings.EqualFold(fieldName, s) }); !ok {
+	return isSortByStructField(fl.Field().String(), reflect.TypeOf(models.Movie{}), filters.MovieSortAliases)
+}
+
+func ValidateSortByReviewField(fl govalidator.FieldLevel) bool {
+	return isSortByStructField(fl.Field().String(), reflect.TypeOf(models.Review{}), filters.ReviewSortAliases)
+}
+
+var permissionCodeRX = regexp.MustCompile(`^[a-z][a-z0-9_-]*:[a-z][a-z0-9_-]*$`)
+
+func ValidatePermissionCode(fl govalidator.FieldLevel) bool {
+	return permissionCodeRX.MatchString(fl.Field().String())
+}
+
+// isSortByStructField checks that the sort refers to the field of t stored in db or to one of the aliases
+func isSortByStructField(sort string, t reflect.Type, aliases map[string]string) bool {
+	sort = strings.ReplaceAll(strings.TrimPrefix(sort, "-"), "_", "")
+	if sort == "" {
+		return false
+	}
+	if alias, ok := aliases[strings.ToLower(sort)]; ok {
+		sort = alias
+	}
+	field, ok := t.FieldByNameFunc(func(s string) bool { return strings.EqualFold(sort, s) })
+	if !ok || field.Tag.Get("db") == "-" {
 		return false
 	}
 	return true
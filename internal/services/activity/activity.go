@@ -0,0 +1,76 @@
+package activity
+
+import (
+	"context"
+	"greenlight/proj/internal/domain/filters"
+	"greenlight/proj/internal/domain/models"
+	"log/slog"
+	"time"
+)
+
+type ReviewsStorage interface {
+	ListForUser(ctx context.Context, userID int64, approvedOnly bool, filters filters.Filters) ([]models.UserReview, int, error)
+}
+
+type MoviesStorage interface {
+	List(ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, fields []string) ([]models.Movie, int, error)
+}
+
+// ActivityService lists contributions of the users, i.e. reviews they have written and movies they have added
+type ActivityService struct {
+	log            *slog.Logger
+	reviewsStorage ReviewsStorage
+	moviesStorage  MoviesStorage
+}
+
+func New(log *slog.Logger, reviewsStorage ReviewsStorage, moviesStorage MoviesStorage) *ActivityService {
+	return &ActivityService{
+		log:            log,
+		reviewsStorage: reviewsStorage,
+		moviesStorage:  moviesStorage,
+	}
+}
+
+// UserReviews returns the page of the user reviews. Reviews which aren't approved yet (or rejected)
+// are returned only if approvedOnly is false, i.e. when users list their own reviews
+func (s *ActivityService) UserReviews(userID int64, approvedOnly bool, page int, pageSize int, sort string) ([]models.UserReview, int, error) {
+	const op = "activity.ActivityService.UserReviews"
+	log := s.log.With("op", op, "userID", userID, "approvedOnly", approvedOnly)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reviewsFilters := filters.Filters{
+		Page:         page,
+		PageSize:     pageSize,
+		Sort:         sort,
+		SortSafelist: filters.StructSortSafelist(models.Review{}),
+		SortAliases:  filters.ReviewSortAliases,
+	}
+	reviews, totalRecords, err := s.reviewsStorage.ListForUser(ctx, userID, approvedOnly, reviewsFilters)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, 0, err
+	}
+	return reviews, totalRecords, nil
+}
+
+// UserMovies returns the page of movies added by the user
+func (s *ActivityService) UserMovies(userID int64, page int, pageSize int, sort string) ([]models.Movie, int, error) {
+	const op = "activity.ActivityService.UserMovies"
+	log := s.log.With("op", op, "userID", userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	movieFilters := filters.MovieFilters{Genres: []string{}, UserID: userID}
+	moviesFilters := filters.Filters{
+		Page:         page,
+		PageSize:     pageSize,
+		Sort:         sort,
+		SortSafelist: filters.StructSortSafelist(models.Movie{}),
+		SortAliases:  filters.MovieSortAliases,
+	}
+	movies, totalRecords, err := s.moviesStorage.List(ctx, movieFilters, moviesFilters, nil)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, 0, err
+	}
+	return movies, totalRecords, nil
+}
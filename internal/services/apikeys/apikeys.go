@@ -0,0 +1,140 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// KeyPrefix marks the keys issued by the app, so they are easy to spot e.g. by secret scanners
+	KeyPrefix = "gl_"
+	// displayedLength is the length of the key start stored in plain text to tell the keys apart
+	displayedLength = len(KeyPrefix) + 8
+)
+
+type ApiKeyStorage interface {
+	Insert(ctx context.Context, userID int64, name string, prefix string, hash []byte, scopes []string, expiresAt *time.Time) (*models.ApiKey, error)
+	ListForUser(ctx context.Context, userID int64) ([]models.ApiKey, error)
+	Use(ctx context.Context, hash []byte) (*models.ApiKey, error)
+	Revoke(ctx context.Context, id int64, userID int64) error
+}
+
+type PermissionChecker interface {
+	CheckPermission(ctx context.Context, permissionCode string, userID int64) (bool, error)
+}
+
+// ApiKeyService manages the API keys machine clients use instead of logging in as the user
+type ApiKeyService struct {
+	log         *slog.Logger
+	storage     ApiKeyStorage
+	permissions PermissionChecker
+}
+
+func New(log *slog.Logger, storage ApiKeyStorage, permissions PermissionChecker) *ApiKeyService {
+	return &ApiKeyService{
+		log:         log,
+		storage:     storage,
+		permissions: permissions,
+	}
+}
+
+// Create issues the named key of the user. Every scope must be the permission the user has.
+// The plain key is returned only once, only its hash is stored
+func (s *ApiKeyService) Create(ctx context.Context, userID int64, name string, scopes []string, expiresAt *time.Time) (*models.ApiKey, string, error) {
+	const op = "apikeys.ApiKeyService.Create"
+	log := s.log.With("op", op, "userID", userID, "name", name)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+	for _, scope := range scopes {
+		granted, err := s.permissions.CheckPermission(ctx, scope, userID)
+		if err != nil {
+			log.Error("Error checking permission", "errMsg", err.Error())
+			return nil, "", err
+		}
+		if !granted {
+			log.Info("scope not granted", "scope", scope)
+			return nil, "", fmt.Errorf("%w: %s", ErrScopeNotGranted, scope)
+		}
+	}
+	plainKey, err := generateKey()
+	if err != nil {
+		log.Error(err.Error())
+		return nil, "", err
+	}
+	key, err := s.storage.Insert(ctx, userID, name, plainKey[:displayedLength], hashKey(plainKey), scopes, expiresAt)
+	if err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			log.Info("duplicate name")
+			return nil, "", ErrDuplicateName
+		}
+		log.Error(err.Error())
+		return nil, "", err
+	}
+	log.Info("api key created", "keyID", key.ID)
+	return key, plainKey, nil
+}
+
+func (s *ApiKeyService) List(ctx context.Context, userID int64) ([]models.ApiKey, error) {
+	const op = "apikeys.ApiKeyService.List"
+	keys, err := s.storage.ListForUser(ctx, userID)
+	if err != nil {
+		s.log.Error(err.Error(), "op", op, "userID", userID)
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (s *ApiKeyService) Revoke(ctx context.Context, id int64, userID int64) error {
+	const op = "apikeys.ApiKeyService.Revoke"
+	log := s.log.With("op", op, "keyID", id, "userID", userID)
+	if err := s.storage.Revoke(ctx, id, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("api key not found")
+			return ErrApiKeyNotFound
+		}
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("api key revoked")
+	return nil
+}
+
+// Authenticate returns the active key by its plain value
+func (s *ApiKeyService) Authenticate(ctx context.Context, plainKey string) (*models.ApiKey, error) {
+	const op = "apikeys.ApiKeyService.Authenticate"
+	if !strings.HasPrefix(plainKey, KeyPrefix) {
+		return nil, ErrInvalidApiKey
+	}
+	key, err := s.storage.Use(ctx, hashKey(plainKey))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, ErrInvalidApiKey
+		}
+		s.log.Error(err.Error(), "op", op)
+		return nil, err
+	}
+	return key, nil
+}
+
+func generateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
@@ -0,0 +1,10 @@
+package apikeys
+
+import "errors"
+
+var (
+	ErrApiKeyNotFound  = errors.New("api key not found")
+	ErrInvalidApiKey   = errors.New("invalid, expired or revoked api key")
+	ErrDuplicateName   = errors.New("api key with this name already exists")
+	ErrScopeNotGranted = errors.New("api key can't be granted the permission the user doesn't have")
+)
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// AccountStorage keeps the closed accounts, which SSO can't store
+//
+//go:generate mockery --name=AccountStorage
+type AccountStorage interface {
+	CloseAccount(ctx context.Context, userID int64) error
+	IsAccountClosed(ctx context.Context, userID int64) (bool, error)
+}
+
+// CloseAccount closes the account of the user and revokes all of its sessions. SSO can't delete users,
+// so the closed account is recorded by the app and its user is never returned as active, see GetUser
+func (a *AuthService) CloseAccount(ctx context.Context, userID int64) error {
+	const op = "auth.AuthService.CloseAccount"
+	log := a.log.With("op", op, "userID", userID)
+	if err := a.accounts.CloseAccount(ctx, userID); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	now := time.Now()
+	if err := a.tokenStorage.RevokeUserTokens(ctx, userID, now, now.Add(a.tokensTTL.Access)); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("account closed")
+	return nil
+}
@@ -0,0 +1,49 @@
+package auth_test
+
+import (
+	"context"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/services/auth"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloseAccount(t *testing.T) {
+	a := newTestAuth(t, auth.Roles{})
+	a.accounts.On("CloseAccount", mock.Anything, int64(1)).Return(nil).Once()
+	a.tokens.On("RevokeUserTokens", mock.Anything, int64(1), mock.Anything, mock.Anything).Return(nil).Once()
+	require.NoError(t, a.CloseAccount(context.Background(), 1))
+}
+
+func TestClosedAccount(t *testing.T) {
+	t.Run("login", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": 1}).SignedString([]byte("secret"))
+		require.NoError(t, err)
+		a.sso.On("Login", mock.Anything, "test@gmail.com", "password").
+			Return(&auth.TokensDTO{AccessToken: accessToken, RefreshToken: "sso-refresh-token"}, nil)
+		a.accounts.On("IsAccountClosed", mock.Anything, int64(1)).Return(true, nil)
+		_, err = a.Login(context.Background(), "test@gmail.com", "password")
+		assert.ErrorIs(t, err, auth.ErrAccountClosed)
+	})
+	t.Run("active user isn't found", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		params := auth.GetUserParams{ID: 1, IsActive: true}
+		a.sso.On("GetUser", mock.Anything, params).Return(&models.User{ID: 1, IsActive: true}, nil)
+		a.accounts.On("IsAccountClosed", mock.Anything, int64(1)).Return(true, nil)
+		_, err := a.GetUser(context.Background(), params)
+		assert.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+	t.Run("user is found by id", func(t *testing.T) {
+		// only the lookups of the active users check the closed accounts
+		a := newTestAuth(t, auth.Roles{})
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 1}).Return(&models.User{ID: 1}, nil)
+		user, err := a.GetUser(context.Background(), auth.GetUserParams{ID: 1})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), user.ID)
+	})
+}
@@ -2,9 +2,12 @@ package auth
 
 import (
 	"context"
+	"crypto/cipher"
 	"greenlight/proj/internal/domain/models"
 	"html/template"
 	"log/slog"
+	"sync"
+	"time"
 )
 
 //go:generate mockery --name=MailProvider
@@ -32,7 +35,10 @@ type SsoProvider interface {
 	NewActivationToken(ctx context.Context, email string) (string, error)
 	VerifyToken(ctx context.Context, token string) (bool, error)
 	CheckPermission(ctx context.Context, permissionCode string, userID int64) (bool, error)
+	CreatePermission(ctx context.Context, code string) error
 	GrantPermissions(ctx context.Context, userID int64, permissions []string) error
+	RenewAccessToken(ctx context.Context, refreshToken string) (string, error)
+	IsAdmin(ctx context.Context, userID int64) (bool, error)
 }
 
 //go:generate mockery --name=TaskExecutor
@@ -45,6 +51,23 @@ type AuthService struct {
 	Mailer       MailProvider
 	sso          SsoProvider
 	taskExecutor TaskExecutor
+	tokenStorage TokenStorage
+	permissions  PermissionStorage
+	accounts     AccountStorage
+	tokensTTL    TokensTTL
+	tokensCipher cipher.AEAD // Encrypts SSO refresh tokens kept in the storage
+	roles        Roles
+
+	// pendingRoles are IDs of the users whose default role failed to be assigned at signup,
+	// the assignment is retried on their logins until it succeeds
+	pendingRoles sync.Map
+}
+
+// TokensTTL are lifetimes of the tokens. Access tokens are issued by SSO, so their lifetime is the upper bound
+// of the SSO one. Lifetime of the refresh token is the lifetime of the login, rotations don't prolong it
+type TokensTTL struct {
+	Access  time.Duration
+	Refresh time.Duration
 }
 
 func New(
@@ -52,12 +75,24 @@ func New(
 	mailer MailProvider,
 	ssoProvider SsoProvider,
 	taskExecutor TaskExecutor,
+	tokenStorage TokenStorage,
+	permissions PermissionStorage,
+	accounts AccountStorage,
+	tokensTTL TokensTTL,
+	tokensCipher cipher.AEAD,
+	roles Roles,
 ) *AuthService {
 	return &AuthService{
 		log:          log,
 		Mailer:       mailer,
 		sso:          ssoProvider,
 		taskExecutor: taskExecutor,
+		tokenStorage: tokenStorage,
+		permissions:  permissions,
+		accounts:     accounts,
+		tokensTTL:    tokensTTL,
+		tokensCipher: tokensCipher,
+		roles:        roles,
 	}
 }
 
@@ -89,6 +124,7 @@ func (a *AuthService) sendActivationEmail(email string, data activationEmailData
 	}
 }
 
+// Signup registers the user in SSO with the default role and mails the activation token in background
 func (a *AuthService) Signup(ctx context.Context, email, username, password, activationURL string) (int64, error) {
 	const op = "auth.AuthService.Signup"
 	log := a.log.With("op", op, "email", email)
@@ -97,10 +133,17 @@ func (a *AuthService) Signup(ctx context.Context, email, username, password, act
 		log.Error("Error calling Sso.Register", "errMsg", err.Error())
 		return 0, err
 	}
-	err = a.sso.GrantPermissions(ctx, data.UserID, []string{"movies:read"})
+	// the user is registered already, so failed assignment of the default role doesn't fail the signup,
+	// the assignment is retried on login instead
+	if permissions := a.roles.Definitions[a.roles.Default]; len(permissions) > 0 {
+		err = a.sso.GrantPermissions(ctx, data.UserID, permissions)
+	}
+	if err == nil {
+		err = a.permissions.SetUserRole(ctx, data.UserID, a.roles.Default, models.SystemUserID)
+	}
 	if err != nil {
-		log.Error("Error calling Sso.GrantPermissions", "errMsg", err.Error())
-		return 0, err
+		log.Error("Error assigning default role", "userID", data.UserID, "errMsg", err.Error())
+		a.pendingRoles.Store(data.UserID, struct{}{})
 	}
 	a.taskExecutor.Add(func() {
 		a.sendActivationEmail(email, activationEmailData{
@@ -113,6 +156,8 @@ func (a *AuthService) Signup(ctx context.Context, email, username, password, act
 	return data.UserID, nil
 }
 
+// Login returns the access token issued by SSO and the refresh token issued by the app, see RefreshTokens.
+// Users whose default role failed to be assigned at signup get it in background
 func (a *AuthService) Login(ctx context.Context, email, password string) (*TokensDTO, error) {
 	const op = "auth.AuthService.Login"
 	log := a.log.With("op", op, "email", email)
@@ -121,7 +166,37 @@ func (a *AuthService) Login(ctx context.Context, email, password string) (*Token
 		log.Error("Error calling Sso.Login", "errMsg", err.Error())
 		return nil, err
 	}
-	return resp, nil
+	userID, err := userIDFromAccessToken(resp.AccessToken)
+	if err != nil {
+		log.Error("Error reading access token", "errMsg", err.Error())
+		return nil, err
+	}
+	closed, err := a.accounts.IsAccountClosed(ctx, userID)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	if closed {
+		log.Info("account is closed", "userID", userID)
+		return nil, ErrAccountClosed
+	}
+	refreshToken, err := a.issueRefreshToken(ctx, resp.AccessToken, resp.RefreshToken)
+	if err != nil {
+		log.Error("Error issuing refresh token", "errMsg", err.Error())
+		return nil, err
+	}
+	if _, pending := a.pendingRoles.Load(userID); pending {
+		a.taskExecutor.Add(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := a.assignDefaultRole(ctx, userID); err != nil {
+				log.Error("Error assigning default role", "userID", userID, "errMsg", err.Error())
+				return
+			}
+			a.pendingRoles.Delete(userID)
+		})
+	}
+	return &TokensDTO{AccessToken: resp.AccessToken, RefreshToken: refreshToken}, nil
 }
 
 func (a *AuthService) GetNewActivationToken(ctx context.Context, email string, activationURL string) error {
@@ -156,10 +231,22 @@ func (a *AuthService) VerifyToken(ctx context.Context, token string) (bool, erro
 	return a.sso.VerifyToken(ctx, token)
 }
 
+// GetUser returns the user from SSO. Users of the closed accounts aren't found if the active user is requested
 func (a *AuthService) GetUser(ctx context.Context, params GetUserParams) (*models.User, error) {
-	return a.sso.GetUser(ctx, params)
+	user, err := a.sso.GetUser(ctx, params)
+	if err != nil || !params.IsActive {
+		return user, err
+	}
+	closed, err := a.accounts.IsAccountClosed(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if closed {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
 }
 
-func (a *AuthService) CheckPermission(ctx context.Context, permissionCode string, userID int64) (bool, error) {
-	return a.sso.CheckPermission(ctx, permissionCode, userID)
+func (a *AuthService) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	return a.sso.IsAdmin(ctx, userID)
 }
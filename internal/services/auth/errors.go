@@ -19,4 +19,11 @@ var (
 	ErrUserNotFound         = errors.New("user not found")
 	ErrInvalidData          = &errInvalidData{}
 	ErrUserAlreadyActivated = errors.New("user already activated")
+	ErrInvalidRefreshToken  = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used, all sessions of the login are revoked")
+	ErrUnknownPermission    = errors.New("permission code isn't registered")
+	ErrPermissionExists     = errors.New("permission code already exists")
+	ErrPermissionNotGranted = errors.New("user doesn't have the permission")
+	ErrUnknownRole          = errors.New("role isn't defined")
+	ErrAccountClosed        = errors.New("account is closed")
 )
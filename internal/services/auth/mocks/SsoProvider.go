@@ -74,6 +74,24 @@ func (_m *SsoProvider) CheckPermission(ctx context.Context, permissionCode strin
 	return r0, r1
 }
 
+// CreatePermission provides a mock function with given fields: ctx, code
+func (_m *SsoProvider) CreatePermission(ctx context.Context, code string) error {
+	ret := _m.Called(ctx, code)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreatePermission")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, code)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // GetUser provides a mock function with given fields: ctx, params
 func (_m *SsoProvider) GetUser(ctx context.Context, params auth.GetUserParams) (*models.User, error) {
 	ret := _m.Called(ctx, params)
@@ -122,6 +140,34 @@ func (_m *SsoProvider) GrantPermissions(ctx context.Context, userID int64, permi
 	return r0
 }
 
+// IsAdmin provides a mock function with given fields: ctx, userID
+func (_m *SsoProvider) IsAdmin(ctx context.Context, userID int64) (bool, error) {
+	ret := _m.Called(ctx, userID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for IsAdmin")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) (bool, error)); ok {
+		return rf(ctx, userID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) bool); ok {
+		r0 = rf(ctx, userID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Login provides a mock function with given fields: ctx, email, password
 func (_m *SsoProvider) Login(ctx context.Context, email string, password string) (*auth.TokensDTO, error) {
 	ret := _m.Called(ctx, email, password)
@@ -210,6 +256,34 @@ func (_m *SsoProvider) Register(ctx context.Context, email string, username stri
 	return r0, r1
 }
 
+// RenewAccessToken provides a mock function with given fields: ctx, refreshToken
+func (_m *SsoProvider) RenewAccessToken(ctx context.Context, refreshToken string) (string, error) {
+	ret := _m.Called(ctx, refreshToken)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RenewAccessToken")
+	}
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (string, error)); ok {
+		return rf(ctx, refreshToken)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, refreshToken)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, refreshToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // VerifyToken provides a mock function with given fields: ctx, token
 func (_m *SsoProvider) VerifyToken(ctx context.Context, token string) (bool, error) {
 	ret := _m.Called(ctx, token)
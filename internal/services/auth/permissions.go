@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// PermissionStorage keeps what SSO can't: the registry of permission codes, so permissions of the user
+// can be listed, revocations which override the grants stored in SSO, and roles of the users
+//
+//go:generate mockery --name=PermissionStorage
+type PermissionStorage interface {
+	InsertPermission(ctx context.Context, code string) error
+	ListPermissions(ctx context.Context) ([]string, error)
+	PermissionExists(ctx context.Context, code string) (bool, error)
+	RevokeUserPermission(ctx context.Context, userID int64, code string, revokedBy int64) error
+	DeleteUserPermissionRevocations(ctx context.Context, userID int64, codes []string) error
+	IsUserPermissionRevoked(ctx context.Context, userID int64, code string) (bool, error)
+	ListUserPermissionRevocations(ctx context.Context, userID int64) ([]string, error)
+	GetUserRole(ctx context.Context, userID int64) (string, error)
+	SetUserRole(ctx context.Context, userID int64, role string, assignedBy int64) error
+	ListRoleUsers(ctx context.Context, role string) ([]int64, error)
+	GetRoleDefinitions(ctx context.Context) (map[string][]string, error)
+	SaveRoleDefinitions(ctx context.Context, definitions map[string][]string) error
+}
+
+// CheckPermission reports whether the user is granted the permission in SSO and it hasn't been revoked since
+func (a *AuthService) CheckPermission(ctx context.Context, permissionCode string, userID int64) (bool, error) {
+	revoked, err := a.IsPermissionRevoked(ctx, permissionCode, userID)
+	if err != nil || revoked {
+		return false, err
+	}
+	return a.sso.CheckPermission(ctx, permissionCode, userID)
+}
+
+// IsPermissionRevoked checks only the revocations, e.g. for API keys whose scopes are checked by the key itself
+func (a *AuthService) IsPermissionRevoked(ctx context.Context, permissionCode string, userID int64) (bool, error) {
+	return a.permissions.IsUserPermissionRevoked(ctx, userID, permissionCode)
+}
+
+// ListPermissions returns the registered permission codes
+func (a *AuthService) ListPermissions(ctx context.Context) ([]string, error) {
+	return a.permissions.ListPermissions(ctx)
+}
+
+// CreatePermission creates the permission code in SSO and registers it. The code which exists in SSO,
+// but isn't registered yet, is registered as is
+func (a *AuthService) CreatePermission(ctx context.Context, code string) error {
+	const op = "auth.AuthService.CreatePermission"
+	log := a.log.With("op", op, "code", code)
+	if err := a.sso.CreatePermission(ctx, code); err != nil && !errors.Is(err, ErrPermissionExists) {
+		log.Error("Error calling Sso.CreatePermission", "errMsg", err.Error())
+		return err
+	}
+	if err := a.permissions.InsertPermission(ctx, code); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			log.Info("permission already exists")
+			return ErrPermissionExists
+		}
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("permission created")
+	return nil
+}
+
+// ssoChecksLimit limits concurrent SSO calls made to check the permissions of the single user
+const ssoChecksLimit = 8
+
+// UserPermissions returns the registered permission codes the user has. SSO can only check the single permission,
+// so it's asked about the codes, which haven't been revoked, concurrently
+func (a *AuthService) UserPermissions(ctx context.Context, userID int64) ([]string, error) {
+	const op = "auth.AuthService.UserPermissions"
+	log := a.log.With("op", op, "userID", userID)
+	if _, err := a.getUser(ctx, userID); err != nil {
+		return nil, err
+	}
+	codes, err := a.permissions.ListPermissions(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	revoked, err := a.permissions.ListUserPermissionRevocations(ctx, userID)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	codes = slices.DeleteFunc(codes, func(code string) bool {
+		return slices.Contains(revoked, code)
+	})
+	granted, err := a.ssoGrantedPermissions(ctx, userID, codes)
+	if err != nil {
+		log.Error("Error calling Sso.CheckPermission", "errMsg", err.Error())
+		return nil, err
+	}
+	return slices.DeleteFunc(codes, func(code string) bool {
+		return !granted[code]
+	}), nil
+}
+
+// GrantPermissions grants the registered permission codes to the user. Revocations of the codes are lifted,
+// SSO is asked to grant only the codes it doesn't store the grants for
+func (a *AuthService) GrantPermissions(ctx context.Context, userID int64, codes []string) error {
+	const op = "auth.AuthService.GrantPermissions"
+	log := a.log.With("op", op, "userID", userID, "codes", codes)
+	if _, err := a.getUser(ctx, userID); err != nil {
+		return err
+	}
+	slices.Sort(codes)
+	codes = slices.Compact(codes)
+	for _, code := range codes {
+		if err := a.checkPermissionExists(ctx, code); err != nil {
+			return err
+		}
+	}
+	granted, err := a.ssoGrantedPermissions(ctx, userID, codes)
+	if err != nil {
+		log.Error("Error calling Sso.CheckPermission", "errMsg", err.Error())
+		return err
+	}
+	var missing []string
+	for _, code := range codes {
+		if !granted[code] {
+			missing = append(missing, code)
+		}
+	}
+	if len(missing) > 0 {
+		if err := a.sso.GrantPermissions(ctx, userID, missing); err != nil {
+			log.Error("Error calling Sso.GrantPermissions", "errMsg", err.Error())
+			return err
+		}
+	}
+	if err := a.permissions.DeleteUserPermissionRevocations(ctx, userID, codes); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("permissions granted")
+	return nil
+}
+
+// RevokePermission revokes the permission of the user. SSO has no RPC to revoke it,
+// so the revocation is stored by the app and overrides the grant in SSO
+func (a *AuthService) RevokePermission(ctx context.Context, userID int64, code string, revokedBy int64) error {
+	const op = "auth.AuthService.RevokePermission"
+	log := a.log.With("op", op, "userID", userID, "code", code)
+	if _, err := a.getUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := a.checkPermissionExists(ctx, code); err != nil {
+		return err
+	}
+	hasPermission, err := a.CheckPermission(ctx, code, userID)
+	if err != nil {
+		log.Error("Error checking permission", "errMsg", err.Error())
+		return err
+	}
+	if !hasPermission {
+		log.Info("permission not granted")
+		return ErrPermissionNotGranted
+	}
+	if err := a.permissions.RevokeUserPermission(ctx, userID, code, revokedBy); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("permission revoked", "revokedBy", revokedBy)
+	return nil
+}
+
+// ssoGrantedPermissions checks the codes in SSO concurrently, revocations aren't taken into account
+func (a *AuthService) ssoGrantedPermissions(ctx context.Context, userID int64, codes []string) (map[string]bool, error) {
+	results := make([]bool, len(codes))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(ssoChecksLimit)
+	for i, code := range codes {
+		group.Go(func() error {
+			granted, err := a.sso.CheckPermission(groupCtx, code, userID)
+			results[i] = granted
+			return err
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	granted := make(map[string]bool, len(codes))
+	for i, code := range codes {
+		granted[code] = results[i]
+	}
+	return granted, nil
+}
+
+func (a *AuthService) checkPermissionExists(ctx context.Context, code string) error {
+	exists, err := a.permissions.PermissionExists(ctx, code)
+	if err != nil {
+		a.log.Error(err.Error(), "code", code)
+		return err
+	}
+	if !exists {
+		return ErrUnknownPermission
+	}
+	return nil
+}
+
+func (a *AuthService) getUser(ctx context.Context, userID int64) (*models.User, error) {
+	user, err := a.sso.GetUser(ctx, GetUserParams{ID: userID})
+	if err != nil {
+		if !errors.Is(err, ErrUserNotFound) {
+			a.log.Error("Error calling Sso.GetUser", "userID", userID, "errMsg", err.Error())
+		}
+		return nil, err
+	}
+	return user, nil
+}
@@ -0,0 +1,79 @@
+package auth_test
+
+import (
+	"context"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/services/auth"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrantPermissions(t *testing.T) {
+	user := &models.User{ID: 1, IsActive: true}
+	t.Run("only missing codes are granted in sso", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 1}).Return(user, nil)
+		a.permissions.On("PermissionExists", mock.Anything, mock.Anything).Return(true, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:read", int64(1)).Return(true, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:write", int64(1)).Return(false, nil)
+		a.sso.On("GrantPermissions", mock.Anything, int64(1), []string{"movies:write"}).Return(nil).Once()
+		a.permissions.On("DeleteUserPermissionRevocations", mock.Anything, int64(1), []string{"movies:read", "movies:write"}).
+			Return(nil).Once()
+		err := a.GrantPermissions(context.Background(), 1, []string{"movies:write", "movies:read", "movies:write"})
+		require.NoError(t, err)
+	})
+	t.Run("unknown code", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 1}).Return(user, nil)
+		a.permissions.On("PermissionExists", mock.Anything, "movies:fly").Return(false, nil)
+		err := a.GrantPermissions(context.Background(), 1, []string{"movies:fly"})
+		assert.ErrorIs(t, err, auth.ErrUnknownPermission)
+	})
+}
+
+func TestRevokePermission(t *testing.T) {
+	user := &models.User{ID: 1, IsActive: true}
+	t.Run("granted", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 1}).Return(user, nil)
+		a.permissions.On("PermissionExists", mock.Anything, "movies:write").Return(true, nil)
+		a.permissions.On("IsUserPermissionRevoked", mock.Anything, int64(1), "movies:write").Return(false, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:write", int64(1)).Return(true, nil)
+		a.permissions.On("RevokeUserPermission", mock.Anything, int64(1), "movies:write", int64(2)).Return(nil).Once()
+		require.NoError(t, a.RevokePermission(context.Background(), 1, "movies:write", 2))
+	})
+	t.Run("not granted", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 1}).Return(user, nil)
+		a.permissions.On("PermissionExists", mock.Anything, "movies:write").Return(true, nil)
+		a.permissions.On("IsUserPermissionRevoked", mock.Anything, int64(1), "movies:write").Return(false, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:write", int64(1)).Return(false, nil)
+		err := a.RevokePermission(context.Background(), 1, "movies:write", 2)
+		assert.ErrorIs(t, err, auth.ErrPermissionNotGranted)
+	})
+}
+
+func TestRevocationOverridesSSO(t *testing.T) {
+	// SSO grants everything, so only the revocations take the permissions away
+	t.Run("check", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.permissions.On("IsUserPermissionRevoked", mock.Anything, int64(1), "movies:write").Return(true, nil)
+		granted, err := a.CheckPermission(context.Background(), "movies:write", 1)
+		require.NoError(t, err)
+		assert.False(t, granted)
+	})
+	t.Run("user permissions", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 1}).Return(&models.User{ID: 1}, nil)
+		a.permissions.On("ListPermissions", mock.Anything).Return([]string{"movies:read", "movies:write", "reviews:moderate"}, nil)
+		a.permissions.On("ListUserPermissionRevocations", mock.Anything, int64(1)).Return([]string{"movies:write"}, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:read", int64(1)).Return(true, nil)
+		a.sso.On("CheckPermission", mock.Anything, "reviews:moderate", int64(1)).Return(true, nil)
+		permissions, err := a.UserPermissions(context.Background(), 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"movies:read", "reviews:moderate"}, permissions)
+	})
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"slices"
+)
+
+// Roles are the bundles of permission codes defined in the config. Every user has the single role,
+// the default one is assigned at signup
+type Roles struct {
+	Default     string
+	Definitions map[string][]string
+}
+
+// RoleDefinitions returns the roles defined in the config
+func (a *AuthService) RoleDefinitions() Roles {
+	return a.roles
+}
+
+// UserRole returns the role of the user. Users signed up before the roles were introduced have the default
+// role until it's assigned on their next login
+func (a *AuthService) UserRole(ctx context.Context, userID int64) (string, error) {
+	role, err := a.permissions.GetUserRole(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return a.roles.Default, nil
+		}
+		a.log.Error(err.Error(), "userID", userID)
+		return "", err
+	}
+	return role, nil
+}
+
+// ChangeUserRole revokes the permissions of the previous role of the user which the new role doesn't have
+// and grants the permissions of the new role
+func (a *AuthService) ChangeUserRole(ctx context.Context, userID int64, role string, changedBy int64) error {
+	const op = "auth.AuthService.ChangeUserRole"
+	log := a.log.With("op", op, "userID", userID, "role", role)
+	newPermissions, defined := a.roles.Definitions[role]
+	if !defined {
+		log.Info("unknown role")
+		return ErrUnknownRole
+	}
+	if _, err := a.getUser(ctx, userID); err != nil {
+		return err
+	}
+	previous, err := a.UserRole(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := a.syncUserPermissions(ctx, userID, a.roles.Definitions[previous], newPermissions, changedBy); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if err := a.permissions.SetUserRole(ctx, userID, role, changedBy); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("role changed", "previous", previous, "changedBy", changedBy)
+	return nil
+}
+
+// SyncRoles registers permission codes of the roles and applies changes of the definitions made since
+// the last sync to the users with the changed roles. Users of the removed roles get the default role.
+// Sync is idempotent, so the definitions are saved only after all the users are synced
+func (a *AuthService) SyncRoles(ctx context.Context) error {
+	const op = "auth.AuthService.SyncRoles"
+	log := a.log.With("op", op)
+	for _, permissions := range a.roles.Definitions {
+		for _, code := range permissions {
+			if err := a.CreatePermission(ctx, code); err != nil && !errors.Is(err, ErrPermissionExists) {
+				return err
+			}
+		}
+	}
+	synced, err := a.permissions.GetRoleDefinitions(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	for role, oldPermissions := range synced {
+		newRole := role
+		newPermissions, defined := a.roles.Definitions[role]
+		if !defined {
+			newRole = a.roles.Default
+			newPermissions = a.roles.Definitions[a.roles.Default]
+		}
+		if defined && samePermissions(oldPermissions, newPermissions) {
+			continue
+		}
+		userIDs, err := a.permissions.ListRoleUsers(ctx, role)
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		log.Info("syncing role", "role", role, "newRole", newRole, "users", len(userIDs))
+		for _, userID := range userIDs {
+			err := a.syncUserPermissions(ctx, userID, oldPermissions, newPermissions, models.SystemUserID)
+			if err != nil && !errors.Is(err, ErrUserNotFound) {
+				log.Error("Error syncing user", "userID", userID, "errMsg", err.Error())
+				return err
+			}
+			if newRole != role {
+				if err := a.permissions.SetUserRole(ctx, userID, newRole, models.SystemUserID); err != nil {
+					log.Error(err.Error())
+					return err
+				}
+			}
+		}
+	}
+	if err := a.permissions.SaveRoleDefinitions(ctx, a.roles.Definitions); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("roles synced")
+	return nil
+}
+
+// assignDefaultRole assigns the default role to the user who has no role yet
+func (a *AuthService) assignDefaultRole(ctx context.Context, userID int64) error {
+	if _, err := a.permissions.GetUserRole(ctx, userID); !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+	if err := a.syncUserPermissions(ctx, userID, nil, a.roles.Definitions[a.roles.Default], models.SystemUserID); err != nil {
+		return err
+	}
+	return a.permissions.SetUserRole(ctx, userID, a.roles.Default, models.SystemUserID)
+}
+
+// syncUserPermissions moves the user from the old set of permissions to the new one
+func (a *AuthService) syncUserPermissions(ctx context.Context, userID int64, oldPermissions []string, newPermissions []string, by int64) error {
+	for _, code := range oldPermissions {
+		if slices.Contains(newPermissions, code) {
+			continue
+		}
+		if err := a.permissions.RevokeUserPermission(ctx, userID, code, by); err != nil {
+			return err
+		}
+	}
+	if len(newPermissions) == 0 {
+		return nil
+	}
+	return a.GrantPermissions(ctx, userID, slices.Clone(newPermissions))
+}
+
+func samePermissions(a []string, b []string) bool {
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(slices.Compact(a), slices.Compact(b))
+}
@@ -0,0 +1,133 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/services/auth"
+	"greenlight/proj/internal/storage"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// expectRegisteredPermissions makes every permission code of the roles already registered
+func expectRegisteredPermissions(a *testAuth) {
+	a.sso.On("CreatePermission", mock.Anything, mock.Anything).Return(auth.ErrPermissionExists)
+	a.permissions.On("InsertPermission", mock.Anything, mock.Anything).Return(storage.ErrConflict)
+}
+
+func TestSyncRoles(t *testing.T) {
+	roles := auth.Roles{
+		Default: "user",
+		Definitions: map[string][]string{
+			"user":   {"movies:read"},
+			"editor": {"movies:read", "movies:write"},
+		},
+	}
+	t.Run("changed role", func(t *testing.T) {
+		a := newTestAuth(t, roles)
+		expectRegisteredPermissions(a)
+		a.permissions.On("GetRoleDefinitions", mock.Anything).Return(map[string][]string{
+			"user":   {"movies:read"},
+			"editor": {"movies:read"},
+		}, nil)
+		a.permissions.On("ListRoleUsers", mock.Anything, "editor").Return([]int64{5}, nil)
+		a.permissions.On("PermissionExists", mock.Anything, mock.Anything).Return(true, nil)
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 5}).Return(&models.User{ID: 5}, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:read", int64(5)).Return(true, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:write", int64(5)).Return(false, nil)
+		a.sso.On("GrantPermissions", mock.Anything, int64(5), []string{"movies:write"}).Return(nil).Once()
+		a.permissions.On("DeleteUserPermissionRevocations", mock.Anything, int64(5), []string{"movies:read", "movies:write"}).Return(nil)
+		a.permissions.On("SaveRoleDefinitions", mock.Anything, roles.Definitions).Return(nil).Once()
+		require.NoError(t, a.SyncRoles(context.Background()))
+	})
+	t.Run("removed role falls back to default", func(t *testing.T) {
+		a := newTestAuth(t, roles)
+		expectRegisteredPermissions(a)
+		a.permissions.On("GetRoleDefinitions", mock.Anything).Return(map[string][]string{
+			"user":    {"movies:read"},
+			"editor":  {"movies:read", "movies:write"},
+			"curator": {"movies:read", "movies:admin"},
+		}, nil)
+		a.permissions.On("ListRoleUsers", mock.Anything, "curator").Return([]int64{6}, nil)
+		a.permissions.On("PermissionExists", mock.Anything, mock.Anything).Return(true, nil)
+		a.permissions.On("RevokeUserPermission", mock.Anything, int64(6), "movies:admin", int64(models.SystemUserID)).Return(nil).Once()
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 6}).Return(&models.User{ID: 6}, nil)
+		a.sso.On("CheckPermission", mock.Anything, "movies:read", int64(6)).Return(true, nil)
+		a.permissions.On("DeleteUserPermissionRevocations", mock.Anything, int64(6), []string{"movies:read"}).Return(nil)
+		a.permissions.On("SetUserRole", mock.Anything, int64(6), "user", int64(models.SystemUserID)).Return(nil).Once()
+		a.permissions.On("SaveRoleDefinitions", mock.Anything, roles.Definitions).Return(nil).Once()
+		require.NoError(t, a.SyncRoles(context.Background()))
+	})
+	t.Run("synced roles are left as is", func(t *testing.T) {
+		// no users are listed, so rerun of the finished sync doesn't touch them
+		a := newTestAuth(t, roles)
+		expectRegisteredPermissions(a)
+		a.permissions.On("GetRoleDefinitions", mock.Anything).Return(map[string][]string{
+			"user":   {"movies:read"},
+			"editor": {"movies:write", "movies:read"},
+		}, nil)
+		a.permissions.On("SaveRoleDefinitions", mock.Anything, roles.Definitions).Return(nil).Once()
+		require.NoError(t, a.SyncRoles(context.Background()))
+	})
+}
+
+func TestChangeUserRole(t *testing.T) {
+	roles := auth.Roles{
+		Default: "user",
+		Definitions: map[string][]string{
+			"user":      {"movies:read"},
+			"editor":    {"movies:read", "movies:write"},
+			"moderator": {"movies:read", "reviews:moderate"},
+		},
+	}
+	t.Run("changed", func(t *testing.T) {
+		a := newTestAuth(t, roles)
+		a.permissions.On("PermissionExists", mock.Anything, mock.Anything).Return(true, nil)
+		a.sso.On("GetUser", mock.Anything, auth.GetUserParams{ID: 7}).Return(&models.User{ID: 7}, nil)
+		a.permissions.On("GetUserRole", mock.Anything, int64(7)).Return("editor", nil)
+		a.permissions.On("RevokeUserPermission", mock.Anything, int64(7), "movies:write", int64(2)).Return(nil).Once()
+		a.sso.On("CheckPermission", mock.Anything, "movies:read", int64(7)).Return(true, nil)
+		a.sso.On("CheckPermission", mock.Anything, "reviews:moderate", int64(7)).Return(false, nil)
+		a.sso.On("GrantPermissions", mock.Anything, int64(7), []string{"reviews:moderate"}).Return(nil).Once()
+		a.permissions.On("DeleteUserPermissionRevocations", mock.Anything, int64(7), []string{"movies:read", "reviews:moderate"}).Return(nil)
+		a.permissions.On("SetUserRole", mock.Anything, int64(7), "moderator", int64(2)).Return(nil).Once()
+		require.NoError(t, a.ChangeUserRole(context.Background(), 7, "moderator", 2))
+	})
+	t.Run("unknown role", func(t *testing.T) {
+		a := newTestAuth(t, roles)
+		err := a.ChangeUserRole(context.Background(), 7, "owner", 2)
+		assert.ErrorIs(t, err, auth.ErrUnknownRole)
+	})
+}
+
+func TestSignupRoleFailure(t *testing.T) {
+	// the user is registered in SSO already, so the role is left to be assigned on the next login
+	a := newTestAuth(t, auth.Roles{Default: "user", Definitions: map[string][]string{"user": {}}})
+	a.sso.On("Register", mock.Anything, "test@gmail.com", "test", "password").
+		Return(&auth.SignupData{UserID: 3, ActivationToken: "token"}, nil)
+	a.permissions.On("SetUserRole", mock.Anything, int64(3), "user", int64(models.SystemUserID)).Return(errors.New("db is down")).Once()
+	a.taskExecutor.On("Add", mock.Anything).Return().Once()
+	userID, err := a.Signup(context.Background(), "test@gmail.com", "test", "password", "activation url")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), userID)
+
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"uid": 3}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+	a.sso.On("Login", mock.Anything, "test@gmail.com", "password").
+		Return(&auth.TokensDTO{AccessToken: accessToken, RefreshToken: "sso-refresh-token"}, nil)
+	a.accounts.On("IsAccountClosed", mock.Anything, int64(3)).Return(false, nil)
+	a.tokens.On("CreateRefreshTokenFamily", mock.Anything, int64(3), mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	a.permissions.On("GetUserRole", mock.Anything, int64(3)).Return("", storage.ErrNotFound).Once()
+	a.permissions.On("SetUserRole", mock.Anything, int64(3), "user", int64(models.SystemUserID)).Return(nil).Once()
+	a.taskExecutor.On("Add", mock.Anything).Run(func(args mock.Arguments) { args.Get(0).(func())() }).Return().Once()
+	// only the first login assigns the role, the next ones don't queue any task
+	for range 2 {
+		_, err = a.Login(context.Background(), "test@gmail.com", "password")
+		require.NoError(t, err)
+	}
+}
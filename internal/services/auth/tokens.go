@@ -0,0 +1,297 @@
+package auth
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"log/slog"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+//go:generate mockery --name=TokenStorage
+type TokenStorage interface {
+	CreateRefreshTokenFamily(ctx context.Context, userID int64, sealedSsoRefreshToken []byte, hash []byte, expiresAt time.Time) error
+	GetRefreshToken(ctx context.Context, hash []byte) (*models.RefreshToken, error)
+	RotateRefreshToken(ctx context.Context, hash []byte, newHash []byte) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyID int64) error
+	RevokeAccessToken(ctx context.Context, tokenID string, userID int64, expiresAt time.Time) error
+	RevokeUserTokens(ctx context.Context, userID int64, revokedBefore time.Time, expiresAt time.Time) error
+	IsAccessTokenRevoked(ctx context.Context, tokenID string, userID int64, issuedAt time.Time) (bool, error)
+	PurgeExpiredRevocations(ctx context.Context) (int64, error)
+	PurgeExpiredRefreshTokens(ctx context.Context) (int64, error)
+}
+
+// RefreshTokens exchanges the refresh token for the new access token and rotates the refresh token itself.
+// Reuse of the rotated token means it has leaked, so the whole family issued since the same login is revoked
+func (a *AuthService) RefreshTokens(ctx context.Context, refreshToken string) (*TokensDTO, error) {
+	const op = "auth.AuthService.RefreshTokens"
+	log := a.log.With("op", op)
+	hash := hashToken(refreshToken)
+	token, err := a.tokenStorage.GetRefreshToken(ctx, hash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("refresh token not found")
+			return nil, ErrInvalidRefreshToken
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	log = log.With("familyID", token.FamilyID, "userID", token.UserID)
+	if token.RevokedAt != nil || time.Now().After(token.ExpiresAt) {
+		log.Info("refresh token is revoked or expired")
+		return nil, ErrInvalidRefreshToken
+	}
+	if token.RotatedAt != nil {
+		return nil, a.revokeReusedFamily(ctx, log, token.FamilyID)
+	}
+	ssoRefreshToken, err := a.openToken(token.SealedSsoRefreshToken)
+	if err != nil {
+		log.Error("Error decrypting sso refresh token", "errMsg", err.Error())
+		return nil, err
+	}
+	accessToken, err := a.sso.RenewAccessToken(ctx, ssoRefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			log.Info("sso refresh token is rejected")
+			return nil, err
+		}
+		log.Error("Error calling Sso.RenewAccessToken", "errMsg", err.Error())
+		return nil, err
+	}
+	newRefreshToken, err := generateOpaqueToken()
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	if err := a.tokenStorage.RotateRefreshToken(ctx, hash, hashToken(newRefreshToken)); err != nil {
+		if errors.Is(err, storage.ErrConflict) {
+			return nil, a.revokeReusedFamily(ctx, log, token.FamilyID)
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	return &TokensDTO{AccessToken: accessToken, RefreshToken: newRefreshToken}, nil
+}
+
+// Logout revokes the access token of the request. If refreshToken is not empty,
+// the refresh token family of the login is revoked too
+func (a *AuthService) Logout(ctx context.Context, accessToken models.AccessToken, refreshToken string) error {
+	const op = "auth.AuthService.Logout"
+	log := a.log.With("op", op, "userID", accessToken.UserID)
+	if err := a.tokenStorage.RevokeAccessToken(ctx, accessToken.ID, accessToken.UserID, accessToken.ExpiresAt); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	token, err := a.tokenStorage.GetRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("refresh token not found")
+			return nil
+		}
+		log.Error(err.Error())
+		return err
+	}
+	if token.UserID != accessToken.UserID {
+		log.Warn("refresh token belongs to another user", "ownerID", token.UserID)
+		return nil
+	}
+	if err := a.tokenStorage.RevokeRefreshTokenFamily(ctx, token.FamilyID); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes all the tokens and API keys issued to the user so far, so the user has to log in again
+func (a *AuthService) RevokeUserSessions(ctx context.Context, userID int64) error {
+	const op = "auth.AuthService.RevokeUserSessions"
+	log := a.log.With("op", op, "userID", userID)
+	if _, err := a.sso.GetUser(ctx, GetUserParams{ID: userID}); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			log.Info("user not found")
+			return err
+		}
+		log.Error("Error calling Sso.GetUser", "errMsg", err.Error())
+		return err
+	}
+	now := time.Now()
+	if err := a.tokenStorage.RevokeUserTokens(ctx, userID, now, now.Add(a.tokensTTL.Access)); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	log.Info("user sessions revoked")
+	return nil
+}
+
+// IsAccessTokenRevoked checks the token against the local revocation list
+func (a *AuthService) IsAccessTokenRevoked(ctx context.Context, accessToken models.AccessToken) (bool, error) {
+	return a.tokenStorage.IsAccessTokenRevoked(ctx, accessToken.ID, accessToken.UserID, accessToken.IssuedAt)
+}
+
+// PurgeExpiredRevocations deletes revocations of the tokens which have expired anyway
+func (a *AuthService) PurgeExpiredRevocations() (int64, error) {
+	const op = "auth.AuthService.PurgeExpiredRevocations"
+	log := a.log.With("op", op)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	purged, err := a.tokenStorage.PurgeExpiredRevocations(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return 0, err
+	}
+	if purged > 0 {
+		log.Info("expired revocations purged", "count", purged)
+	}
+	return purged, nil
+}
+
+// PurgeExpiredRefreshTokens deletes refresh token families, which have expired or have been revoked,
+// with all their tokens. Tokens of such families are rejected anyway
+func (a *AuthService) PurgeExpiredRefreshTokens() (int64, error) {
+	const op = "auth.AuthService.PurgeExpiredRefreshTokens"
+	log := a.log.With("op", op)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	purged, err := a.tokenStorage.PurgeExpiredRefreshTokens(ctx)
+	if err != nil {
+		log.Error(err.Error())
+		return 0, err
+	}
+	if purged > 0 {
+		log.Info("expired refresh token families purged", "count", purged)
+	}
+	return purged, nil
+}
+
+// AccessTokenFromClaims describes the verified access token of the user. Tokens without jti claim are identified
+// by their hash. If SSO hasn't set iat or exp claims, they are derived from the access token lifetime
+func (a *AuthService) AccessTokenFromClaims(rawToken string, userID int64, claims jwt.MapClaims) models.AccessToken {
+	accessToken := models.AccessToken{UserID: userID}
+	if jti, ok := claims["jti"].(string); ok && jti != "" {
+		accessToken.ID = jti
+	} else {
+		accessToken.ID = hex.EncodeToString(hashToken(rawToken))
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		accessToken.ExpiresAt = exp.Time
+	} else {
+		accessToken.ExpiresAt = time.Now().Add(a.tokensTTL.Access)
+	}
+	if iat, err := claims.GetIssuedAt(); err == nil && iat != nil {
+		accessToken.IssuedAt = iat.Time
+	} else {
+		accessToken.IssuedAt = accessToken.ExpiresAt.Add(-a.tokensTTL.Access)
+	}
+	return accessToken
+}
+
+// issueRefreshToken starts the family of refresh tokens for the login. The SSO refresh token is kept
+// on the server encrypted, while the client gets the opaque one
+func (a *AuthService) issueRefreshToken(ctx context.Context, accessToken string, ssoRefreshToken string) (string, error) {
+	userID, err := userIDFromAccessToken(accessToken)
+	if err != nil {
+		return "", err
+	}
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	sealedSsoRefreshToken, err := a.sealToken(ssoRefreshToken)
+	if err != nil {
+		return "", err
+	}
+	expiresAt := time.Now().Add(a.tokensTTL.Refresh)
+	if err := a.tokenStorage.CreateRefreshTokenFamily(ctx, userID, sealedSsoRefreshToken, hashToken(refreshToken), expiresAt); err != nil {
+		return "", err
+	}
+	return refreshToken, nil
+}
+
+func (a *AuthService) revokeReusedFamily(ctx context.Context, log *slog.Logger, familyID int64) error {
+	log.Warn("rotated refresh token is reused, revoking the token family")
+	if err := a.tokenStorage.RevokeRefreshTokenFamily(ctx, familyID); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+// NewTokensCipher makes the cipher encrypting tokens kept in the storage from the hex encoded 256 bits key
+func NewTokensCipher(hexKey string) (cipher.AEAD, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("tokens encryption key isn't hex encoded: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("tokens encryption key must be 32 bytes long, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealToken encrypts the token to store. The random nonce is prepended to the ciphertext
+func (a *AuthService) sealToken(token string) ([]byte, error) {
+	nonce := make([]byte, a.tokensCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return a.tokensCipher.Seal(nonce, nonce, []byte(token), nil), nil
+}
+
+// openToken decrypts the token sealed by sealToken
+func (a *AuthService) openToken(sealed []byte) (string, error) {
+	nonceSize := a.tokensCipher.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", errors.New("sealed token is too short")
+	}
+	token, err := a.tokensCipher.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(token), nil
+}
+
+// generateOpaqueToken returns the random URL safe token with 256 bits of entropy
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash of the token to store. Tokens are random, so the plain SHA-256 is enough
+func hashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// userIDFromAccessToken reads the user ID from the access token just issued by SSO.
+// The token comes from SSO directly, so its signature isn't verified here
+func userIDFromAccessToken(accessToken string) (int64, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(accessToken, claims); err != nil {
+		return 0, err
+	}
+	userID, ok := claims["uid"].(float64)
+	if !ok {
+		return 0, fmt.Errorf("access token has no uid claim")
+	}
+	return int64(userID), nil
+}
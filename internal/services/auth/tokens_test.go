@@ -0,0 +1,137 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/services/auth"
+	"greenlight/proj/internal/services/auth/mocks"
+	"greenlight/proj/internal/storage"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// testAuth is the auth service built with mocks, which fail the test on unexpected calls
+type testAuth struct {
+	*auth.AuthService
+	mailer       *mocks.MailProvider
+	sso          *mocks.SsoProvider
+	taskExecutor *mocks.TaskExecutor
+	tokens       *mocks.TokenStorage
+	permissions  *mocks.PermissionStorage
+	accounts     *mocks.AccountStorage
+}
+
+func newTestAuth(t *testing.T, roles auth.Roles) *testAuth {
+	tokensCipher, err := auth.NewTokensCipher(strings.Repeat("ab", 32))
+	require.NoError(t, err)
+	a := &testAuth{
+		mailer:       mocks.NewMailProvider(t),
+		sso:          mocks.NewSsoProvider(t),
+		taskExecutor: mocks.NewTaskExecutor(t),
+		tokens:       mocks.NewTokenStorage(t),
+		permissions:  mocks.NewPermissionStorage(t),
+		accounts:     mocks.NewAccountStorage(t),
+	}
+	a.AuthService = auth.New(
+		slog.New(slog.NewTextHandler(io.Discard, nil)),
+		a.mailer,
+		a.sso,
+		a.taskExecutor,
+		a.tokens,
+		a.permissions,
+		a.accounts,
+		auth.TokensTTL{Access: time.Hour, Refresh: 24 * time.Hour},
+		tokensCipher,
+		roles,
+	)
+	return a
+}
+
+// sealTestToken encrypts the token the same way the service keeps SSO refresh tokens
+func sealTestToken(t *testing.T, token string) []byte {
+	tokensCipher, err := auth.NewTokensCipher(strings.Repeat("ab", 32))
+	require.NoError(t, err)
+	nonce := make([]byte, tokensCipher.NonceSize())
+	_, err = rand.Read(nonce)
+	require.NoError(t, err)
+	return tokensCipher.Seal(nonce, nonce, []byte(token), nil)
+}
+
+func TestRefreshTokens(t *testing.T) {
+	const refreshToken = "client-refresh-token"
+	hash := sha256.Sum256([]byte(refreshToken))
+	ptr := func(v time.Time) *time.Time { return &v }
+	activeToken := func() *models.RefreshToken {
+		return &models.RefreshToken{
+			Hash:                  hash[:],
+			FamilyID:              7,
+			UserID:                1,
+			SealedSsoRefreshToken: sealTestToken(t, "sso-refresh-token"),
+			ExpiresAt:             time.Now().Add(time.Hour),
+		}
+	}
+	t.Run("rotation", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.tokens.On("GetRefreshToken", mock.Anything, hash[:]).Return(activeToken(), nil)
+		a.sso.On("RenewAccessToken", mock.Anything, "sso-refresh-token").Return("access-token", nil)
+		var newHash []byte
+		a.tokens.On("RotateRefreshToken", mock.Anything, hash[:], mock.Anything).
+			Run(func(args mock.Arguments) { newHash = args.Get(2).([]byte) }).
+			Return(nil)
+		tokens, err := a.RefreshTokens(context.Background(), refreshToken)
+		require.NoError(t, err)
+		assert.Equal(t, "access-token", tokens.AccessToken)
+		assert.NotEqual(t, refreshToken, tokens.RefreshToken)
+		expectedHash := sha256.Sum256([]byte(tokens.RefreshToken))
+		assert.Equal(t, expectedHash[:], newHash, "hash of the new token must be stored")
+	})
+	t.Run("reuse revokes the family", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		token := activeToken()
+		token.RotatedAt = ptr(time.Now().Add(-time.Minute))
+		a.tokens.On("GetRefreshToken", mock.Anything, hash[:]).Return(token, nil)
+		a.tokens.On("RevokeRefreshTokenFamily", mock.Anything, int64(7)).Return(nil).Once()
+		_, err := a.RefreshTokens(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	})
+	t.Run("concurrent rotation revokes the family", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.tokens.On("GetRefreshToken", mock.Anything, hash[:]).Return(activeToken(), nil)
+		a.sso.On("RenewAccessToken", mock.Anything, "sso-refresh-token").Return("access-token", nil)
+		a.tokens.On("RotateRefreshToken", mock.Anything, hash[:], mock.Anything).Return(storage.ErrConflict)
+		a.tokens.On("RevokeRefreshTokenFamily", mock.Anything, int64(7)).Return(nil).Once()
+		_, err := a.RefreshTokens(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	})
+	t.Run("expired", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		token := activeToken()
+		token.ExpiresAt = time.Now().Add(-time.Second)
+		a.tokens.On("GetRefreshToken", mock.Anything, hash[:]).Return(token, nil)
+		_, err := a.RefreshTokens(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+	})
+	t.Run("revoked", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		token := activeToken()
+		token.RevokedAt = ptr(time.Now().Add(-time.Minute))
+		a.tokens.On("GetRefreshToken", mock.Anything, hash[:]).Return(token, nil)
+		_, err := a.RefreshTokens(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		a := newTestAuth(t, auth.Roles{})
+		a.tokens.On("GetRefreshToken", mock.Anything, hash[:]).Return(nil, storage.ErrNotFound)
+		_, err := a.RefreshTokens(context.Background(), refreshToken)
+		assert.ErrorIs(t, err, auth.ErrInvalidRefreshToken)
+	})
+}
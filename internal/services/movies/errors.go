@@ -7,4 +7,7 @@ var (
 	ErrMovieAlreadyExists = errors.New("movie with that title, version and year already exists")
 	ErrNoArgumentsChanged = errors.New("no arguments changed")
 	ErrEditConflict       = errors.New("unable to update the record due to an edit conflict, please try again")
+	ErrPreconditionFailed = errors.New("movie has been changed since the version you have")
+	ErrRevisionNotFound   = errors.New("movie revision not found")
+	ErrInvalidCursor      = errors.New("invalid or expired cursor, it doesn't match the requested sort")
 )
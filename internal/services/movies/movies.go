@@ -8,44 +8,58 @@ import (
 	"greenlight/proj/internal/domain/models"
 	"greenlight/proj/internal/storage"
 	"log/slog"
-	"reflect"
+	"slices"
 	"time"
 )
 
 //go:generate mockery --name=MoviesStorage --output=../../storage/postgres/models/mocks
 type MoviesStorage interface {
-	Get(ctx context.Context, id int) (*models.Movie, error)
-	Insert(ctx context.Context, title string, year int32, runtime fields.MovieRuntime, genres []string) (*models.Movie, error)
-	List(ctx context.Context, title string, genres []string, filters filters.Filters) ([]models.Movie, int, error)
-	Update(ctx context.Context, movie *models.Movie) (*models.Movie, error)
-	Delete(ctx context.Context, id int) error
+	Get(ctx context.Context, id int, fields []string) (*models.Movie, error)
+	Insert(ctx context.Context, title string, year int32, runtime fields.MovieRuntime, genres []string, userID int64) (*models.Movie, error)
+	InsertMany(ctx context.Context, movies []*models.Movie, dryRun bool) ([]error, error)
+	List(ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, fields []string) ([]models.Movie, int, error)
+	ListByCursor(ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, fields []string) ([]models.Movie, error)
+	Export(ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, withReviews bool, fn func(*models.Movie) error) error
+	Update(ctx context.Context, movie *models.Movie, userID int64, changes map[string]models.FieldChange) (*models.Movie, error)
+	ListRevisions(ctx context.Context, movieID int64) ([]models.MovieRevision, error)
+	GetRevision(ctx context.Context, movieID int64, version uint) (*models.MovieRevision, error)
+	Delete(ctx context.Context, id int, version uint) error
+	Restore(ctx context.Context, id int) (*models.Movie, error)
+	PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, error)
+	RatingSummaries(ctx context.Context, movieIDs []int64) (map[int64]models.RatingSummary, error)
+	Search(ctx context.Context, q string, movieFilters filters.MovieFilters, filters filters.Filters, fields []string) ([]models.MovieSearchHit, int, error)
 }
 
 //go:generate mockery --name=ReviewsStorage --output=../../storage/postgres/models/mocks
 type ReviewsStorage interface {
 	GetForMovie(ctx context.Context, movieID int64) ([]models.Review, error)
+	GetForMovies(ctx context.Context, movieIDs []int64) (map[int64][]models.Review, error)
 }
 
 type MovieService struct {
 	log            *slog.Logger
 	moviesStorage  MoviesStorage
 	reviewsStorage ReviewsStorage
+	cursorKey      []byte // Signs the pagination cursors
 }
 
-func New(log *slog.Logger, moviesStorage MoviesStorage, reviewsStorage ReviewsStorage) *MovieService {
+func New(log *slog.Logger, moviesStorage MoviesStorage, reviewsStorage ReviewsStorage, cursorKey []byte) *MovieService {
 	return &MovieService{
 		log:            log,
 		moviesStorage:  moviesStorage,
 		reviewsStorage: reviewsStorage,
+		cursorKey:      cursorKey,
 	}
 }
 
-func (s *MovieService) Get(id int) (*models.Movie, error) {
+// Get returns the movie with the projection fields only.
+// Reviews and rating summary are loaded only if the projection includes them
+func (s *MovieService) Get(id int, projection filters.Projection) (*models.Movie, error) {
 	const op = "movies.MovieService.Get"
 	log := s.log.With("op", op, "id", id)
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	movie, err := s.moviesStorage.Get(ctx, id)
+	movie, err := s.moviesStorage.Get(ctx, id, projection.Fields)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			log.Info("movie not found")
@@ -54,26 +68,37 @@ func (s *MovieService) Get(id int) (*models.Movie, error) {
 		log.Error(err.Error())
 		return nil, err
 	}
-	reviews, err := s.reviewsStorage.GetForMovie(ctx, movie.ID)
-	if err != nil {
-		switch {
-		case errors.Is(err, storage.ErrNotFound):
-			reviews = make([]models.Review, 0)
-		default:
+	if projection.Includes(filters.IncludeReviews) {
+		reviews, err := s.reviewsStorage.GetForMovie(ctx, movie.ID)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				reviews = make([]models.Review, 0)
+			default:
+				log.Error(err.Error())
+				return nil, err
+			}
+		}
+		movie.Reviews = reviews
+	}
+	if projection.Includes(filters.IncludeRatingSummary) {
+		summaries, err := s.moviesStorage.RatingSummaries(ctx, []int64{movie.ID})
+		if err != nil {
 			log.Error(err.Error())
 			return nil, err
 		}
+		summary := summaries[movie.ID]
+		movie.RatingSummary = &summary
 	}
-	movie.Reviews = reviews
 	return movie, nil
 }
 
-func (s *MovieService) Create(title string, year int32, runtime fields.MovieRuntime, genres []string) (*models.Movie, error) {
+func (s *MovieService) Create(title string, year int32, runtime fields.MovieRuntime, genres []string, userID int64) (*models.Movie, error) {
 	const op = "movies.MovieService.Create"
-	log := s.log.With("op", op, "title", title, "year", year, "runtime", runtime, "genres", genres)
+	log := s.log.With("op", op, "title", title, "year", year, "runtime", runtime, "genres", genres, "userID", userID)
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	movie, err := s.moviesStorage.Insert(ctx, title, year, runtime, genres)
+	movie, err := s.moviesStorage.Insert(ctx, title, year, runtime, genres, userID)
 	if err != nil {
 		if errors.Is(err, storage.ErrConflict) {
 			log.Info("movie already exists")
@@ -85,23 +110,42 @@ func (s *MovieService) Create(title string, year int32, runtime fields.MovieRunt
 	return movie, nil
 }
 
-func (s *MovieService) List(title string, genres []string, page int, pageSize int, sort string) ([]models.Movie, int, error) {
+// CreateMany inserts movies all at once, so either all of them are saved or none. Errors for the single movies
+// are returned in errs, ErrMovieAlreadyExists for the duplicates, either of existing movies or within movies, and nil for the rest.
+// With dryRun movies are checked against the db, but not saved
+func (s *MovieService) CreateMany(movies []*models.Movie, dryRun bool) (errs []error, err error) {
+	const op = "movies.MovieService.CreateMany"
+	log := s.log.With("op", op, "count", len(movies), "dryRun", dryRun)
+	// allow 10 seconds per every started 500 movies
+	timeout := time.Duration(len(movies)/500+1) * 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	errs, err = s.moviesStorage.InsertMany(ctx, movies, dryRun)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	for i, err := range errs {
+		if errors.Is(err, storage.ErrConflict) {
+			errs[i] = ErrMovieAlreadyExists
+		}
+	}
+	return errs, nil
+}
+
+func (s *MovieService) List(movieFilters filters.MovieFilters, projection filters.Projection, page int, pageSize int, sort string) ([]models.Movie, int, error) {
 	const op = "movies.MovieService.List"
 	log := s.log.With("op", op)
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	movieFieldsNum := reflect.TypeOf(models.Movie{}).NumField()
-	movieFields := make([]string, 0, movieFieldsNum)
-	for i := 0; i < movieFieldsNum; i++ {
-		movieFields = append(movieFields, reflect.TypeOf(models.Movie{}).Field(i).Name)
-	}
 	filters := filters.Filters{
 		Page:         page,
 		PageSize:     pageSize,
 		Sort:         sort,
-		SortSafelist: movieFields,
+		SortSafelist: movieSortSafelist(),
+		SortAliases:  filters.MovieSortAliases,
 	}
-	movies, totalRecords, err := s.moviesStorage.List(ctx, title, genres, filters)
+	movies, totalRecords, err := s.moviesStorage.List(ctx, movieFilters, filters, projection.Fields)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
 			log.Info("movies not found")
@@ -110,13 +154,118 @@ func (s *MovieService) List(title string, genres []string, page int, pageSize in
 		log.Error(err.Error())
 		return nil, 0, err
 	}
+	if err := s.embed(ctx, movies, projection); err != nil {
+		log.Error(err.Error())
+		return nil, 0, err
+	}
 	return movies, totalRecords, nil
 }
 
-func (s *MovieService) Update(id int, title *string, year *int32, runtime *fields.MovieRuntime, genres []string) (*models.Movie, error) {
+// Search returns the page of movies matching the query ordered by relevance
+func (s *MovieService) Search(q string, movieFilters filters.MovieFilters, projection filters.Projection, page int, pageSize int) ([]models.MovieSearchHit, int, error) {
+	const op = "movies.MovieService.Search"
+	log := s.log.With("op", op, "q", q)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	searchFilters := filters.Filters{Page: page, PageSize: pageSize}
+	hits, totalRecords, err := s.moviesStorage.Search(ctx, q, movieFilters, searchFilters, projection.Fields)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, 0, err
+	}
+	movies := make([]models.Movie, 0, len(hits))
+	for _, hit := range hits {
+		movies = append(movies, hit.Movie)
+	}
+	if err := s.embed(ctx, movies, projection); err != nil {
+		log.Error(err.Error())
+		return nil, 0, err
+	}
+	for i := range hits {
+		hits[i].Movie = movies[i]
+	}
+	return hits, totalRecords, nil
+}
+
+// ListByCursor returns the page of movies adjacent to the cursor (the first page if cursor is empty)
+// alongside with cursors pointing to the next and previous pages. Cursor is empty if there is no such page
+func (s *MovieService) ListByCursor(movieFilters filters.MovieFilters, projection filters.Projection, cursor string, pageSize int, sort string) (movies []models.Movie, nextCursor string, prevCursor string, err error) {
+	const op = "movies.MovieService.ListByCursor"
+	log := s.log.With("op", op, "cursor", cursor)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	listFilters := filters.Filters{
+		PageSize:     pageSize,
+		Sort:         sort,
+		SortSafelist: movieSortSafelist(),
+		SortAliases:  filters.MovieSortAliases,
+	}
+	if cursor != "" {
+		listFilters.Cursor, err = filters.DecodeCursor(cursor, s.cursorKey)
+		if err != nil || listFilters.Cursor.Sort != sort {
+			log.Info("invalid cursor")
+			return nil, "", "", ErrInvalidCursor
+		}
+		if _, err := listFilters.CursorValue(models.Movie{}); err != nil {
+			log.Info("invalid cursor value")
+			return nil, "", "", ErrInvalidCursor
+		}
+	}
+	movies, err = s.moviesStorage.ListByCursor(ctx, movieFilters, listFilters, projection.Fields)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, "", "", err
+	}
+	hasMore := len(movies) > pageSize
+	if hasMore {
+		movies = movies[:pageSize]
+	}
+	if len(movies) == 0 {
+		return movies, "", "", nil
+	}
+	backward := listFilters.IsBackward()
+	if backward {
+		slices.Reverse(movies)
+	}
+	if err := s.embed(ctx, movies, projection); err != nil {
+		log.Error(err.Error())
+		return nil, "", "", err
+	}
+	if hasMore || backward {
+		last := movies[len(movies)-1]
+		nextCursor = listFilters.NewCursor(last, last.ID, filters.CursorNext).Encode(s.cursorKey)
+	}
+	if (hasMore && backward) || (!backward && listFilters.Cursor != nil) {
+		prevCursor = listFilters.NewCursor(movies[0], movies[0].ID, filters.CursorPrev).Encode(s.cursorKey)
+	}
+	return movies, nextCursor, prevCursor, nil
+}
+
+// Export calls fn for every movie matching the filters. Unlike other methods it accepts the context,
+// because export of the whole catalog may take a while and should live as long as the client is reading it
+func (s *MovieService) Export(
+	ctx context.Context, movieFilters filters.MovieFilters, sort string, withReviews bool, fn func(*models.Movie) error,
+) error {
+	const op = "movies.MovieService.Export"
+	log := s.log.With("op", op, "sort", sort, "withReviews", withReviews)
+	exportFilters := filters.Filters{
+		Sort:         sort,
+		SortSafelist: movieSortSafelist(),
+		SortAliases:  filters.MovieSortAliases,
+	}
+	if err := s.moviesStorage.Export(ctx, movieFilters, exportFilters, withReviews, fn); err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Update applies not nil arguments to the movie. If expectedVersion is set, the movie is updated
+// only if it still has that version, otherwise ErrPreconditionFailed is returned
+func (s *MovieService) Update(id int, userID int64, expectedVersion *uint, title *string, year *int32, runtime *fields.MovieRuntime, genres []string) (*models.Movie, error) {
 	const op = "movies.MovieService.Update"
 	log := s.log.With("op", op, "id", id, "title", title, "year", year, "runtime", runtime, "genres", genres)
-	movie, err := s.Get(id)
+	movie, err := s.Get(id, filters.Projection{})
 	if err != nil {
 		if errors.Is(err, ErrMovieNotFound) {
 			log.Info("movie not found")
@@ -125,30 +274,74 @@ func (s *MovieService) Update(id int, title *string, year *int32, runtime *field
 		log.Error("Error getting movie: " + err.Error())
 		return nil, err
 	}
-	var argsChanged int
+	if expectedVersion != nil && movie.Version != *expectedVersion {
+		log.Info("movie version doesn't match the expected one")
+		return nil, ErrPreconditionFailed
+	}
+	original := movie.Snapshot()
 	if title != nil {
 		movie.Title = *title
-		argsChanged++
 	}
 	if year != nil {
 		movie.Year = *year
-		argsChanged++
 	}
 	if runtime != nil {
 		movie.Runtime = *runtime
-		argsChanged++
 	}
 	if genres != nil {
 		movie.Genres = genres
-		argsChanged++
 	}
-	if argsChanged == 0 {
+	return s.save(log, movie, original, userID, expectedVersion != nil)
+}
+
+// Revert restores the movie to the state it had at the specified version.
+// Reverting is saved as a regular update, so it produces a new version and fails on concurrent changes
+func (s *MovieService) Revert(id int, version uint, userID int64, expectedVersion *uint) (*models.Movie, error) {
+	const op = "movies.MovieService.Revert"
+	log := s.log.With("op", op, "id", id, "version", version)
+	movie, err := s.Get(id, filters.Projection{})
+	if err != nil {
+		if errors.Is(err, ErrMovieNotFound) {
+			log.Info("movie not found")
+			return nil, ErrMovieNotFound
+		}
+		log.Error("Error getting movie: " + err.Error())
+		return nil, err
+	}
+	if expectedVersion != nil && movie.Version != *expectedVersion {
+		log.Info("movie version doesn't match the expected one")
+		return nil, ErrPreconditionFailed
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	revision, err := s.moviesStorage.GetRevision(ctx, movie.ID, version)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("revision not found")
+			return nil, ErrRevisionNotFound
+		}
+		log.Error("Error getting revision: " + err.Error())
+		return nil, err
+	}
+	original := movie.Snapshot()
+	movie.Title = revision.Snapshot.Title
+	movie.Year = revision.Snapshot.Year
+	movie.Runtime = revision.Snapshot.Runtime
+	movie.Genres = revision.Snapshot.Genres
+	return s.save(log, movie, original, userID, expectedVersion != nil)
+}
+
+// save stores changes made to the movie since it was in the original state.
+// Concurrent update is reported as ErrPreconditionFailed if the client has asked for the particular version
+func (s *MovieService) save(log *slog.Logger, movie *models.Movie, original models.MovieSnapshot, userID int64, versionExpected bool) (*models.Movie, error) {
+	changes := models.DiffMovieSnapshots(&original, movie.Snapshot())
+	if len(changes) == 0 {
 		log.Info("no arguments changed")
 		return nil, ErrNoArgumentsChanged
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	updatedMovie, err := s.moviesStorage.Update(ctx, movie)
+	updatedMovie, err := s.moviesStorage.Update(ctx, movie, userID, changes)
 	if err != nil {
 		switch {
 		case errors.Is(err, storage.ErrConflict):
@@ -156,6 +349,9 @@ func (s *MovieService) Update(id int, title *string, year *int32, runtime *field
 			return nil, ErrMovieAlreadyExists
 		case errors.Is(err, storage.ErrNotFound):
 			log.Warn("Update conflict, because of concurrent update")
+			if versionExpected {
+				return nil, ErrPreconditionFailed
+			}
 			return nil, ErrEditConflict
 		default:
 			log.Error("Error updating movie: " + err.Error())
@@ -165,14 +361,75 @@ func (s *MovieService) Update(id int, title *string, year *int32, runtime *field
 	return updatedMovie, nil
 }
 
-func (s *MovieService) Delete(id int) error {
+func (s *MovieService) ListRevisions(movieID int) ([]models.MovieRevision, error) {
+	const op = "movies.MovieService.ListRevisions"
+	log := s.log.With("op", op, "movieID", movieID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := s.moviesStorage.Get(ctx, movieID, nil); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("movie not found")
+			return nil, ErrMovieNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	revisions, err := s.moviesStorage.ListRevisions(ctx, int64(movieID))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (s *MovieService) GetRevision(movieID int, version uint) (*models.MovieRevision, error) {
+	const op = "movies.MovieService.GetRevision"
+	log := s.log.With("op", op, "movieID", movieID, "version", version)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	revision, err := s.moviesStorage.GetRevision(ctx, int64(movieID), version)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("revision not found")
+			return nil, ErrRevisionNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	return revision, nil
+}
+
+// Delete moves the movie to the trash. If expectedVersion is set, the movie is deleted
+// only if it still has that version, otherwise ErrPreconditionFailed is returned
+func (s *MovieService) Delete(id int, expectedVersion *uint) error {
 	const op = "movies.MovieService.Delete"
 	log := s.log.With("op", op, "id", id)
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 	defer cancel()
-	err := s.moviesStorage.Delete(ctx, id)
+	var version uint
+	if expectedVersion != nil {
+		movie, err := s.moviesStorage.Get(ctx, id, nil)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Info("movie not found")
+				return ErrMovieNotFound
+			}
+			log.Error(err.Error())
+			return err
+		}
+		if movie.Version != *expectedVersion {
+			log.Info("movie version doesn't match the expected one")
+			return ErrPreconditionFailed
+		}
+		version = *expectedVersion
+	}
+	err := s.moviesStorage.Delete(ctx, id, version)
 	if err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
+			if expectedVersion != nil {
+				log.Warn("Delete conflict, because of concurrent update")
+				return ErrPreconditionFailed
+			}
 			log.Info("movie not found")
 			return ErrMovieNotFound
 		}
@@ -181,3 +438,88 @@ func (s *MovieService) Delete(id int) error {
 	}
 	return nil
 }
+
+// ListTrash returns the page of movies moved to the trash
+func (s *MovieService) ListTrash(page int, pageSize int, sort string) ([]models.Movie, int, error) {
+	return s.List(filters.MovieFilters{Genres: []string{}, Deleted: true}, filters.Projection{}, page, pageSize, sort)
+}
+
+// Restore moves the movie back from the trash. Returns ErrMovieAlreadyExists if the same movie has been created
+// since it was deleted, so the live one has to be deleted first
+func (s *MovieService) Restore(id int) (*models.Movie, error) {
+	const op = "movies.MovieService.Restore"
+	log := s.log.With("op", op, "id", id)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	movie, err := s.moviesStorage.Restore(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			log.Info("movie not found in the trash")
+			return nil, ErrMovieNotFound
+		case errors.Is(err, storage.ErrConflict):
+			log.Info("movie has been created again since it was deleted")
+			return nil, ErrMovieAlreadyExists
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	return movie, nil
+}
+
+// PurgeTrash permanently deletes movies which have been in the trash for longer than retention period
+func (s *MovieService) PurgeTrash(retention time.Duration) (int64, error) {
+	const op = "movies.MovieService.PurgeTrash"
+	log := s.log.With("op", op, "retention", retention)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	purged, err := s.moviesStorage.PurgeTrash(ctx, time.Now().Add(-retention))
+	if err != nil {
+		log.Error(err.Error())
+		return 0, err
+	}
+	if purged > 0 {
+		log.Info("movies purged from the trash", "count", purged)
+	}
+	return purged, nil
+}
+
+// embed loads data included into the projection for the page of movies.
+// Reviews and rating summaries are loaded in batches, so the number of queries doesn't depend on the page size
+func (s *MovieService) embed(ctx context.Context, movies []models.Movie, projection filters.Projection) error {
+	if len(movies) == 0 {
+		return nil
+	}
+	ids := make([]int64, 0, len(movies))
+	for _, movie := range movies {
+		ids = append(ids, movie.ID)
+	}
+	if projection.Includes(filters.IncludeReviews) {
+		reviews, err := s.reviewsStorage.GetForMovies(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for i := range movies {
+			movies[i].Reviews = reviews[movies[i].ID]
+			if movies[i].Reviews == nil {
+				movies[i].Reviews = make([]models.Review, 0)
+			}
+		}
+	}
+	if projection.Includes(filters.IncludeRatingSummary) {
+		summaries, err := s.moviesStorage.RatingSummaries(ctx, ids)
+		if err != nil {
+			return err
+		}
+		for i := range movies {
+			summary := summaries[movies[i].ID]
+			movies[i].RatingSummary = &summary
+		}
+	}
+	return nil
+}
+
+// movieSortSafelist lists movie fields which are stored in db, so the movies can be ordered by them
+func movieSortSafelist() []string {
+	return filters.StructSortSafelist(models.Movie{})
+}
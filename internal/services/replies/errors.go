@@ -0,0 +1,16 @@
+package replies
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrReviewNotFound     = errors.New("review not found")
+	ErrReplyNotFound      = errors.New("reply not found")
+	ErrParentNotFound     = errors.New("parent reply not found in the thread of the review")
+	ErrTooDeep            = fmt.Errorf("replies can't be nested more than %d levels deep", MaxDepth)
+	ErrNotReplyAuthor     = errors.New("only the author can edit the reply")
+	ErrCantDeleteReply    = errors.New("only the author or a moderator can delete the reply")
+	ErrNoArgumentsChanged = errors.New("no arguments changed")
+)
@@ -0,0 +1,216 @@
+package replies
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/filters"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"log/slog"
+	"time"
+)
+
+// MaxDepth limits nesting of the replies, so the threads stay readable
+const MaxDepth = 5
+
+type ReplyStorage interface {
+	Insert(ctx context.Context, reviewID int64, parentID *int64, userID int64, body string, depth int) (*models.ReviewReply, error)
+	Get(ctx context.Context, id int64) (*models.ReviewReply, error)
+	List(ctx context.Context, reviewID int64, parentID *int64, filters filters.Filters) ([]models.ReviewReply, int, error)
+	Update(ctx context.Context, id int64, body string) (*models.ReviewReply, error)
+	Delete(ctx context.Context, id int64) error
+}
+
+type ReviewStorage interface {
+	Get(ctx context.Context, id int64) (*models.Review, error)
+}
+
+// ReplyService manages the reply threads under the reviews
+type ReplyService struct {
+	log           *slog.Logger
+	storage       ReplyStorage
+	reviewStorage ReviewStorage
+}
+
+func New(log *slog.Logger, storage ReplyStorage, reviewStorage ReviewStorage) *ReplyService {
+	return &ReplyService{
+		log:           log,
+		storage:       storage,
+		reviewStorage: reviewStorage,
+	}
+}
+
+// Create replies to the public review, or to the reply in its thread if parentID is not nil
+func (s *ReplyService) Create(reviewID int64, parentID *int64, userID int64, body string) (*models.ReviewReply, error) {
+	const op = "replies.ReplyService.Create"
+	log := s.log.With("op", op, "reviewID", reviewID, "parentID", parentID, "userID", userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	review, err := s.getThreadReview(ctx, log, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.DeletedAt != nil {
+		log.Info("review is deleted")
+		return nil, ErrReviewNotFound
+	}
+	depth := 0
+	if parentID != nil {
+		parent, err := s.getParent(ctx, log, reviewID, *parentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.DeletedAt != nil {
+			log.Info("parent reply is deleted")
+			return nil, ErrParentNotFound
+		}
+		depth = parent.Depth + 1
+	}
+	if depth >= MaxDepth {
+		log.Info("reply is too deep", "depth", depth)
+		return nil, ErrTooDeep
+	}
+	reply, err := s.storage.Insert(ctx, reviewID, parentID, userID, body, depth)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return reply, nil
+}
+
+// List returns the review and the page of direct replies to it, or to the reply in its thread if parentID is not nil.
+// Threads of the deleted reviews are still listed, the review is returned as a tombstone then
+func (s *ReplyService) List(reviewID int64, parentID *int64, page int, pageSize int) (*models.Review, []models.ReviewReply, int, error) {
+	const op = "replies.ReplyService.List"
+	log := s.log.With("op", op, "reviewID", reviewID, "parentID", parentID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	review, err := s.getThreadReview(ctx, log, reviewID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if review.DeletedAt != nil {
+		// rating is the content of the review as well as the comment
+		review.Rating = 0
+	}
+	if parentID != nil {
+		if _, err := s.getParent(ctx, log, reviewID, *parentID); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	replies, totalRecords, err := s.storage.List(ctx, reviewID, parentID, filters.Filters{Page: page, PageSize: pageSize})
+	if err != nil {
+		log.Error(err.Error())
+		return nil, nil, 0, err
+	}
+	return review, replies, totalRecords, nil
+}
+
+// Update changes the body of the reply. Only the author can edit the reply
+func (s *ReplyService) Update(id int64, userID int64, body string) (*models.ReviewReply, error) {
+	const op = "replies.ReplyService.Update"
+	log := s.log.With("op", op, "id", id, "userID", userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply, err := s.get(ctx, log, id)
+	if err != nil {
+		return nil, err
+	}
+	if reply.UserID != userID {
+		log.Info("user is not the author of the reply")
+		return nil, ErrNotReplyAuthor
+	}
+	if reply.Body == body {
+		log.Info("no arguments changed")
+		return nil, ErrNoArgumentsChanged
+	}
+	updatedReply, err := s.storage.Update(ctx, id, body)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("reply not found")
+			return nil, ErrReplyNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	return updatedReply, nil
+}
+
+// Delete deletes the reply. Only the author can delete the reply, unless canModerate is true
+func (s *ReplyService) Delete(id int64, userID int64, canModerate bool) error {
+	const op = "replies.ReplyService.Delete"
+	log := s.log.With("op", op, "id", id, "userID", userID, "canModerate", canModerate)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	reply, err := s.get(ctx, log, id)
+	if err != nil {
+		return err
+	}
+	if reply.UserID != userID && !canModerate {
+		log.Info("user is not the author of the reply")
+		return ErrCantDeleteReply
+	}
+	if err := s.storage.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("reply not found")
+			return ErrReplyNotFound
+		}
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// getThreadReview returns the review if its thread is public, i.e. the review is approved
+func (s *ReplyService) getThreadReview(ctx context.Context, log *slog.Logger, reviewID int64) (*models.Review, error) {
+	review, err := s.reviewStorage.Get(ctx, reviewID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("review not found")
+			return nil, ErrReviewNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	if review.Status != models.ReviewApproved {
+		log.Info("review is not public", "status", review.Status)
+		return nil, ErrReviewNotFound
+	}
+	return review, nil
+}
+
+// getParent returns the reply if it belongs to the thread of the review
+func (s *ReplyService) getParent(ctx context.Context, log *slog.Logger, reviewID int64, parentID int64) (*models.ReviewReply, error) {
+	parent, err := s.storage.Get(ctx, parentID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("parent reply not found")
+			return nil, ErrParentNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	if parent.ReviewID != reviewID {
+		log.Info("parent reply belongs to another review", "parentReviewID", parent.ReviewID)
+		return nil, ErrParentNotFound
+	}
+	return parent, nil
+}
+
+// get returns the reply unless it's a tombstone
+func (s *ReplyService) get(ctx context.Context, log *slog.Logger, id int64) (*models.ReviewReply, error) {
+	reply, err := s.storage.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("reply not found")
+			return nil, ErrReplyNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	if reply.DeletedAt != nil {
+		log.Info("reply is deleted")
+		return nil, ErrReplyNotFound
+	}
+	return reply, nil
+}
@@ -4,4 +4,12 @@ import "errors"
 
 var (
 	ErrReviewAlreadyExists = errors.New("review already exists")
+	ErrReviewNotFound      = errors.New("review not found")
+	ErrMovieNotFound       = errors.New("movie not found")
+	ErrNotReviewAuthor     = errors.New("only the author or a moderator can change the review")
+	ErrNoArgumentsChanged  = errors.New("no arguments changed")
+	ErrAlreadyFlagged      = errors.New("you have already flagged this review")
+	ErrOwnReviewVote       = errors.New("you can't vote for your own review")
+	ErrVoteNotFound        = errors.New("you haven't voted for this review")
+	ErrCommentRejected     = errors.New("comment rejected")
 )
@@ -3,34 +3,79 @@ package reviews
 import (
 	"context"
 	"errors"
+	"fmt"
+	"greenlight/proj/internal/domain/filters"
 	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/lib/contentfilter"
 	"greenlight/proj/internal/storage"
 	"log/slog"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type ReviewStorage interface {
-	Insert(ctx context.Context, rating int32, comment string, movieID int64, userID int64) (*models.Review, error)
+	Insert(ctx context.Context, rating int32, comment string, movieID int64, userID int64, flagReason string) (*models.Review, error)
+	Get(ctx context.Context, id int64) (*models.Review, error)
+	List(ctx context.Context, movieID int64, filters filters.Filters) ([]models.Review, int, error)
+	Update(ctx context.Context, review *models.Review) (*models.Review, error)
+	Delete(ctx context.Context, id int64) error
+	Flag(ctx context.Context, reviewID int64, userID int64, reason string, threshold int) (*models.ReviewFlag, error)
+	ListModerationQueue(ctx context.Context, statuses []string, filters filters.Filters) ([]models.ModerationQueueItem, int, error)
+	Moderate(ctx context.Context, id int64, status string, reason *string, moderatorID int64) (*models.Review, error)
+	Vote(ctx context.Context, reviewID int64, userID int64, helpful bool) error
+	DeleteVote(ctx context.Context, reviewID int64, userID int64) error
 }
 
+// ContentFilter checks the comments before they are saved. Saved comments are recorded,
+// so the limits of the user submissions apply to them only
+type ContentFilter interface {
+	Check(userID int64, text string) contentfilter.Verdict
+	Record(userID int64, text string)
+}
+
+// maxCommentLength limits the comments. Masks applied by the content filter may make the comment longer,
+// so the masked one is checked again
+const maxCommentLength = 255
+
 type ReviewService struct {
-	log     *slog.Logger
-	storage ReviewStorage
+	log            *slog.Logger
+	storage        ReviewStorage
+	contentFilter  ContentFilter
+	flagsThreshold int // Number of user flags hiding the approved review until moderation
 }
 
-func New(log *slog.Logger, storage ReviewStorage) *ReviewService {
+func New(log *slog.Logger, storage ReviewStorage, contentFilter ContentFilter, flagsThreshold int) *ReviewService {
 	return &ReviewService{
-		log:     log,
-		storage: storage,
+		log:            log,
+		storage:        storage,
+		contentFilter:  contentFilter,
+		flagsThreshold: flagsThreshold,
 	}
 }
 
+// Create saves the review after the comment passes the content filter. The comment may be saved masked,
+// and the review is flagged for moderators if the filter asks for it
 func (s *ReviewService) Create(rating int32, comment string, movieID int64, userID int64) (*models.Review, error) {
 	const op = "reviews.ReviewService.Create"
 	log := s.log.With("op", op, "rating", rating, "comment", comment, "movieID", movieID, "userID", userID)
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	review, err := s.storage.Insert(ctx, rating, comment, movieID, userID)
+	verdict := s.contentFilter.Check(userID, comment)
+	var flagReason string
+	switch verdict.Action {
+	case contentfilter.Reject:
+		log.Info("comment rejected by content filter", "reasons", verdict.Reasons)
+		return nil, fmt.Errorf("%w: %s", ErrCommentRejected, strings.Join(verdict.Reasons, "; "))
+	case contentfilter.Flag:
+		log.Info("comment flagged by content filter", "reasons", verdict.Reasons)
+		flagReason = strings.Join(verdict.Reasons, "; ")
+	}
+	if err := checkMaskedLength(verdict.Text); err != nil {
+		log.Info("masked comment is too long")
+		return nil, err
+	}
+	review, err := s.storage.Insert(ctx, rating, verdict.Text, movieID, userID, flagReason)
 	if err != nil {
 		if errors.Is(err, storage.ErrConflict) {
 			log.Info("review already exists")
@@ -39,5 +84,263 @@ func (s *ReviewService) Create(rating int32, comment string, movieID int64, user
 		log.Error(err.Error())
 		return nil, err
 	}
+	s.contentFilter.Record(userID, verdict.Text)
+	return review, nil
+}
+
+// List returns the page of the movie reviews
+func (s *ReviewService) List(movieID int64, page int, pageSize int, sort string) ([]models.Review, int, error) {
+	const op = "reviews.ReviewService.List"
+	log := s.log.With("op", op, "movieID", movieID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	filters := filters.Filters{
+		Page:         page,
+		PageSize:     pageSize,
+		Sort:         sort,
+		SortSafelist: reviewSortSafelist(),
+		SortAliases:  filters.ReviewSortAliases,
+	}
+	reviews, totalRecords, err := s.storage.List(ctx, movieID, filters)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("movie not found")
+			return nil, 0, ErrMovieNotFound
+		}
+		log.Error(err.Error())
+		return nil, 0, err
+	}
+	return reviews, totalRecords, nil
+}
+
+// Update applies not nil arguments to the review. Only the author can update the review,
+// unless canModerate is true. Review changed by the author has to be moderated again
+func (s *ReviewService) Update(id int64, userID int64, canModerate bool, rating *int32, comment *string) (*models.Review, error) {
+	const op = "reviews.ReviewService.Update"
+	log := s.log.With("op", op, "id", id, "userID", userID, "canModerate", canModerate)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	review, err := s.getOwned(ctx, log, id, userID, canModerate)
+	if err != nil {
+		return nil, err
+	}
+	changed, filtered := false, false
+	if rating != nil && int(*rating) != review.Rating {
+		review.Rating = int(*rating)
+		changed = true
+	}
+	if comment != nil && *comment != review.Comment {
+		review.Comment = *comment
+		changed = true
+		// review changed by the author goes to moderation anyway, so only rejections and masks are applied
+		if !canModerate {
+			verdict := s.contentFilter.Check(userID, review.Comment)
+			if verdict.Action == contentfilter.Reject {
+				log.Info("comment rejected by content filter", "reasons", verdict.Reasons)
+				return nil, fmt.Errorf("%w: %s", ErrCommentRejected, strings.Join(verdict.Reasons, "; "))
+			}
+			if err := checkMaskedLength(verdict.Text); err != nil {
+				log.Info("masked comment is too long")
+				return nil, err
+			}
+			review.Comment = verdict.Text
+			filtered = true
+		}
+	}
+	if !changed {
+		log.Info("no arguments changed")
+		return nil, ErrNoArgumentsChanged
+	}
+	if !canModerate {
+		review.Status = models.ReviewPending
+	}
+	updatedReview, err := s.storage.Update(ctx, review)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("review not found")
+			return nil, ErrReviewNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	if filtered {
+		s.contentFilter.Record(userID, review.Comment)
+	}
+	return updatedReview, nil
+}
+
+// Delete deletes the review. Only the author can delete the review, unless canModerate is true
+func (s *ReviewService) Delete(id int64, userID int64, canModerate bool) error {
+	const op = "reviews.ReviewService.Delete"
+	log := s.log.With("op", op, "id", id, "userID", userID, "canModerate", canModerate)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if _, err := s.getOwned(ctx, log, id, userID, canModerate); err != nil {
+		return err
+	}
+	if err := s.storage.Delete(ctx, id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("review not found")
+			return ErrReviewNotFound
+		}
+		log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Flag reports the review to moderators. The approved review stays public until it's flagged by
+// the threshold number of users or moderator decides on it
+func (s *ReviewService) Flag(reviewID int64, userID int64, reason string) (*models.ReviewFlag, error) {
+	const op = "reviews.ReviewService.Flag"
+	log := s.log.With("op", op, "reviewID", reviewID, "userID", userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	flag, err := s.storage.Flag(ctx, reviewID, userID, reason, s.flagsThreshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			log.Info("review not found")
+			return nil, ErrReviewNotFound
+		case errors.Is(err, storage.ErrConflict):
+			log.Info("review already flagged by the user")
+			return nil, ErrAlreadyFlagged
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	return flag, nil
+}
+
+// ModerationQueue returns the page of reviews with the statuses, the oldest ones first
+func (s *ReviewService) ModerationQueue(statuses []string, page int, pageSize int) ([]models.ModerationQueueItem, int, error) {
+	const op = "reviews.ReviewService.ModerationQueue"
+	log := s.log.With("op", op, "statuses", statuses)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	items, totalRecords, err := s.storage.ListModerationQueue(ctx, statuses, filters.Filters{Page: page, PageSize: pageSize})
+	if err != nil {
+		log.Error(err.Error())
+		return nil, 0, err
+	}
+	return items, totalRecords, nil
+}
+
+// Approve publishes the review. Reason is optional for approvals
+func (s *ReviewService) Approve(id int64, moderatorID int64, reason *string) (*models.Review, error) {
+	return s.moderate(id, models.ReviewApproved, reason, moderatorID)
+}
+
+// Reject hides the review with the reason shown to its author
+func (s *ReviewService) Reject(id int64, moderatorID int64, reason string) (*models.Review, error) {
+	return s.moderate(id, models.ReviewRejected, &reason, moderatorID)
+}
+
+func (s *ReviewService) moderate(id int64, status string, reason *string, moderatorID int64) (*models.Review, error) {
+	const op = "reviews.ReviewService.moderate"
+	log := s.log.With("op", op, "id", id, "status", status, "moderatorID", moderatorID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	review, err := s.storage.Moderate(ctx, id, status, reason, moderatorID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("review not found")
+			return nil, ErrReviewNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
 	return review, nil
 }
+
+// Vote marks the public review helpful or unhelpful. Repeated vote of the user replaces the previous one.
+// Returns the review with updated counters
+func (s *ReviewService) Vote(reviewID int64, userID int64, helpful bool) (*models.Review, error) {
+	const op = "reviews.ReviewService.Vote"
+	log := s.log.With("op", op, "reviewID", reviewID, "userID", userID, "helpful", helpful)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	review, err := s.getPublic(ctx, log, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review.UserID == userID {
+		log.Info("user voted for own review")
+		return nil, ErrOwnReviewVote
+	}
+	if err := s.storage.Vote(ctx, reviewID, userID, helpful); err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return s.getPublic(ctx, log, reviewID)
+}
+
+// DeleteVote retracts the user vote. Returns the review with updated counters
+func (s *ReviewService) DeleteVote(reviewID int64, userID int64) (*models.Review, error) {
+	const op = "reviews.ReviewService.DeleteVote"
+	log := s.log.With("op", op, "reviewID", reviewID, "userID", userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := s.storage.DeleteVote(ctx, reviewID, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("vote not found")
+			return nil, ErrVoteNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	return s.getPublic(ctx, log, reviewID)
+}
+
+// getPublic returns the review if it's approved and not deleted, other reviews are hidden from users
+func (s *ReviewService) getPublic(ctx context.Context, log *slog.Logger, id int64) (*models.Review, error) {
+	review, err := s.storage.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("review not found")
+			return nil, ErrReviewNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	if review.Status != models.ReviewApproved || review.DeletedAt != nil {
+		log.Info("review is not public", "status", review.Status, "deleted", review.DeletedAt != nil)
+		return nil, ErrReviewNotFound
+	}
+	return review, nil
+}
+
+// getOwned returns the review if the user is its author or can moderate reviews. Tombstones can't be changed
+func (s *ReviewService) getOwned(ctx context.Context, log *slog.Logger, id int64, userID int64, canModerate bool) (*models.Review, error) {
+	review, err := s.storage.Get(ctx, id)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("review not found")
+			return nil, ErrReviewNotFound
+		}
+		log.Error(err.Error())
+		return nil, err
+	}
+	if review.DeletedAt != nil {
+		log.Info("review is deleted")
+		return nil, ErrReviewNotFound
+	}
+	if review.UserID != userID && !canModerate {
+		log.Info("user is not the author of the review")
+		return nil, ErrNotReviewAuthor
+	}
+	return review, nil
+}
+
+// reviewSortSafelist lists review fields which are stored in db, so the reviews can be ordered by them
+func reviewSortSafelist() []string {
+	return filters.StructSortSafelist(models.Review{})
+}
+
+// checkMaskedLength rejects the comment if masks made it longer than allowed
+func checkMaskedLength(comment string) error {
+	if utf8.RuneCountInString(comment) > maxCommentLength {
+		return fmt.Errorf("%w: comment is longer than %d characters after masking", ErrCommentRejected, maxCommentLength)
+	}
+	return nil
+}
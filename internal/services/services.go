@@ -1,24 +1,37 @@
 package services
 
 import (
+	"fmt"
+	"greenlight/proj/internal/clients/sso/cache"
 	"greenlight/proj/internal/clients/sso/grpc"
 	"greenlight/proj/internal/config"
+	"greenlight/proj/internal/lib/contentfilter"
+	"greenlight/proj/internal/lib/jwtverifier"
 	"greenlight/proj/internal/mails"
+	"greenlight/proj/internal/services/activity"
+	"greenlight/proj/internal/services/apikeys"
 	"greenlight/proj/internal/services/auth"
 	authmocks "greenlight/proj/internal/services/auth/mocks"
 	"greenlight/proj/internal/services/movies"
+	"greenlight/proj/internal/services/replies"
 	"greenlight/proj/internal/services/reviews"
 	"greenlight/proj/internal/storage/postgres"
 	"greenlight/proj/internal/storage/postgres/models"
 	"log/slog"
 	"os"
 	"testing"
+	"time"
 )
 
 type Services struct {
-	Auth    *auth.AuthService
-	Movies  *movies.MovieService
-	Reviews *reviews.ReviewService
+	Auth          *auth.AuthService
+	Movies        *movies.MovieService
+	Reviews       *reviews.ReviewService
+	Replies       *replies.ReplyService
+	Activity      *activity.ActivityService
+	ApiKeys       *apikeys.ApiKeyService
+	ContentFilter *contentfilter.Filter
+	TokenVerifier *jwtverifier.Verifier
 }
 
 func New(log *slog.Logger, cfg *config.Config, storage *postgres.Storage, taskExecutor auth.TaskExecutor) *Services {
@@ -38,17 +51,95 @@ func New(log *slog.Logger, cfg *config.Config, storage *postgres.Storage, taskEx
 	if err != nil {
 		panic(err)
 	}
+	var ssoProvider auth.SsoProvider = sso
+	if cacheCfg := cfg.Clients.SSOCache; cacheCfg.Enabled {
+		ssoProvider = cache.New(sso, cache.Options{
+			Capacity:      cacheCfg.Capacity,
+			UserTTL:       cacheCfg.UserTTL,
+			PermissionTTL: cacheCfg.PermissionTTL,
+			AdminTTL:      cacheCfg.AdminTTL,
+			TokenTTL:      cacheCfg.TokenTTL,
+		})
+	}
+	contentFilter, err := contentfilter.New(cfg.ContentFilter.RulesPath)
+	if err != nil {
+		panic(err)
+	}
+	tokenVerifier, err := newTokenVerifier(cfg, ssoProvider)
+	if err != nil {
+		panic(err)
+	}
+	tokensTTL := auth.TokensTTL{Access: cfg.Tokens.AccessTTL, Refresh: cfg.Tokens.RefreshTTL}
+	tokensCipher, err := auth.NewTokensCipher(cfg.Tokens.EncryptionKey)
+	if err != nil {
+		panic(err)
+	}
+	roles := auth.Roles{Default: cfg.Roles.Default, Definitions: cfg.Roles.Definitions}
+	if len(roles.Definitions) == 0 {
+		roles.Definitions = map[string][]string{roles.Default: {"movies:read"}}
+	}
+	if _, defined := roles.Definitions[roles.Default]; !defined {
+		panic(fmt.Errorf("default role %q isn't defined", roles.Default))
+	}
+	authService := auth.New(log, mailer, ssoProvider, taskExecutor, models.Token, models.Permission, models.Account, tokensTTL, tokensCipher, roles)
 	return &Services{
-		Auth:    auth.New(log, mailer, sso, taskExecutor),
-		Movies:  movies.New(log, models.Movie, models.Review),
-		Reviews: reviews.New(log, models.Review),
+		Auth:          authService,
+		Movies:        movies.New(log, models.Movie, models.Review, []byte(cfg.AppSecret)),
+		Reviews:       reviews.New(log, models.Review, contentFilter, cfg.Reviews.FlagsThreshold),
+		Replies:       replies.New(log, models.Reply, models.Review),
+		Activity:      activity.New(log, models.Review, models.Movie),
+		ApiKeys:       apikeys.New(log, models.ApiKey, authService),
+		ContentFilter: contentFilter,
+		TokenVerifier: tokenVerifier,
+	}
+}
+
+// newTokenVerifier makes the verifier of access tokens with the keys from config. SSO verifies tokens
+// signed with unknown keys only if the fallback is enabled
+func newTokenVerifier(cfg *config.Config, sso jwtverifier.Fallback) (*jwtverifier.Verifier, error) {
+	var keys []jwtverifier.Key
+	for _, keyCfg := range cfg.JWT.Keys {
+		secret := keyCfg.Secret
+		if secret == "" && keyCfg.SecretEnv != "" {
+			secret = os.Getenv(keyCfg.SecretEnv)
+		}
+		key, err := jwtverifier.LoadKey(keyCfg.ID, keyCfg.Algorithm, secret, keyCfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		keys = append(keys, jwtverifier.Key{ID: cfg.JWT.DefaultKey, Algorithm: "HS256", Key: []byte(cfg.AppSecret)})
+	}
+	options := jwtverifier.Options{
+		Algorithms: cfg.JWT.Algorithms,
+		Issuer:     cfg.JWT.Issuer,
+		Audience:   cfg.JWT.Audience,
+		Leeway:     cfg.JWT.Leeway,
+		DefaultKey: cfg.JWT.DefaultKey,
+	}
+	if cfg.JWT.SSOFallback {
+		options.Fallback = sso
 	}
+	return jwtverifier.New(keys, options)
 }
 
 func NewTestServices(t *testing.T) *Services {
 	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{}))
 	return &Services{
-		Auth:   auth.New(log, authmocks.NewMailProvider(t), authmocks.NewSsoProvider(t), authmocks.NewTaskExecutor(t)),
-		Movies: movies.New(log, nil, nil),
+		Auth: auth.New(
+			log,
+			authmocks.NewMailProvider(t),
+			authmocks.NewSsoProvider(t),
+			authmocks.NewTaskExecutor(t),
+			authmocks.NewTokenStorage(t),
+			authmocks.NewPermissionStorage(t),
+			authmocks.NewAccountStorage(t),
+			auth.TokensTTL{Access: time.Hour, Refresh: time.Hour},
+			nil,
+			auth.Roles{Default: "user", Definitions: map[string][]string{"user": {"movies:read"}}},
+		),
+		Movies: movies.New(log, nil, nil, []byte("secret")),
 	}
 }
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AccountModel struct {
+	DB *pgxpool.Pool
+}
+
+func (m *AccountModel) CloseAccount(ctx context.Context, userID int64) error {
+	_, err := m.DB.Exec(ctx, "INSERT INTO closed_accounts (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING", userID)
+	return err
+}
+
+func (m *AccountModel) IsAccountClosed(ctx context.Context, userID int64) (bool, error) {
+	var closed bool
+	err := m.DB.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM closed_accounts WHERE user_id = $1)", userID).Scan(&closed)
+	return closed, err
+}
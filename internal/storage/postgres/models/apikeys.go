@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"greenlight/proj/internal/storage/postgres"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ApiKeyModel struct {
+	DB *pgxpool.Pool
+}
+
+// Insert saves the key. Returns storage.ErrConflict if the user already has the active key with the same name
+func (m *ApiKeyModel) Insert(ctx context.Context, userID int64, name string, prefix string, hash []byte, scopes []string, expiresAt *time.Time) (*models.ApiKey, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at, revoked_at`,
+		userID,
+		name,
+		prefix,
+		hash,
+		scopes,
+		expiresAt,
+	)
+	key, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.ApiKey])
+	if err != nil {
+		var pgxErr *pgconn.PgError
+		if errors.As(err, &pgxErr) && pgxErr.Code == postgres.ErrConflictCode {
+			return nil, storage.ErrConflict
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListForUser selects the keys of the user from the newest, revoked ones included
+func (m *ApiKeyModel) ListForUser(ctx context.Context, userID int64) ([]models.ApiKey, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at, revoked_at
+		FROM api_keys WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	return pgx.CollectRows(rows, pgx.RowToStructByName[models.ApiKey])
+}
+
+// Use selects the active key by its hash and records the time of its use. The use is recorded at most once a minute,
+// so every request doesn't write to db, and the key is returned as it was before the use. Revoked and expired keys aren't selected, storage.ErrNotFound is returned for them
+func (m *ApiKeyModel) Use(ctx context.Context, hash []byte) (*models.ApiKey, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`WITH key AS (
+			SELECT id, user_id, name, prefix, hash, scopes, created_at, expires_at, last_used_at, revoked_at
+			FROM api_keys WHERE hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		), used AS (
+			UPDATE api_keys SET last_used_at = NOW()
+			WHERE id IN (SELECT id FROM key) AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+		)
+		SELECT * FROM key`,
+		hash,
+	)
+	key, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.ApiKey])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// Revoke revokes the active key of the user. Returns storage.ErrNotFound if there's no such key
+func (m *ApiKeyModel) Revoke(ctx context.Context, id int64, userID int64) error {
+	status, err := m.DB.Exec(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL", id, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
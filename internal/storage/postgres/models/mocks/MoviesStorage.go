@@ -10,6 +10,8 @@ import (
 	mock "github.com/stretchr/testify/mock"
 
 	models "greenlight/proj/internal/domain/models"
+
+	time "time"
 )
 
 // MoviesStorage is an autogenerated mock type for the MoviesStorage type
@@ -17,17 +19,17 @@ type MoviesStorage struct {
 	mock.Mock
 }
 
-// Delete provides a mock function with given fields: ctx, id
-func (_m *MoviesStorage) Delete(ctx context.Context, id int) error {
-	ret := _m.Called(ctx, id)
+// Delete provides a mock function with given fields: ctx, id, version
+func (_m *MoviesStorage) Delete(ctx context.Context, id int, version uint) error {
+	ret := _m.Called(ctx, id, version)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Delete")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, int) error); ok {
-		r0 = rf(ctx, id)
+	if rf, ok := ret.Get(0).(func(context.Context, int, uint) error); ok {
+		r0 = rf(ctx, id, version)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -35,9 +37,27 @@ func (_m *MoviesStorage) Delete(ctx context.Context, id int) error {
 	return r0
 }
 
-// Get provides a mock function with given fields: ctx, id
-func (_m *MoviesStorage) Get(ctx context.Context, id int) (*models.Movie, error) {
-	ret := _m.Called(ctx, id)
+// Export provides a mock function with given fields: ctx, movieFilters, _a2, withReviews, fn
+func (_m *MoviesStorage) Export(ctx context.Context, movieFilters filters.MovieFilters, _a2 filters.Filters, withReviews bool, fn func(*models.Movie) error) error {
+	ret := _m.Called(ctx, movieFilters, _a2, withReviews, fn)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Export")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, filters.MovieFilters, filters.Filters, bool, func(*models.Movie) error) error); ok {
+		r0 = rf(ctx, movieFilters, _a2, withReviews, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Get provides a mock function with given fields: ctx, id, _a2
+func (_m *MoviesStorage) Get(ctx context.Context, id int, _a2 []string) (*models.Movie, error) {
+	ret := _m.Called(ctx, id, _a2)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Get")
@@ -45,19 +65,19 @@ func (_m *MoviesStorage) Get(ctx context.Context, id int) (*models.Movie, error)
 
 	var r0 *models.Movie
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, int) (*models.Movie, error)); ok {
-		return rf(ctx, id)
+	if rf, ok := ret.Get(0).(func(context.Context, int, []string) (*models.Movie, error)); ok {
+		return rf(ctx, id, _a2)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, int) *models.Movie); ok {
-		r0 = rf(ctx, id)
+	if rf, ok := ret.Get(0).(func(context.Context, int, []string) *models.Movie); ok {
+		r0 = rf(ctx, id, _a2)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*models.Movie)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
-		r1 = rf(ctx, id)
+	if rf, ok := ret.Get(1).(func(context.Context, int, []string) error); ok {
+		r1 = rf(ctx, id, _a2)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -65,9 +85,39 @@ func (_m *MoviesStorage) Get(ctx context.Context, id int) (*models.Movie, error)
 	return r0, r1
 }
 
-// Insert provides a mock function with given fields: ctx, title, year, runtime, genres
-func (_m *MoviesStorage) Insert(ctx context.Context, title string, year int32, runtime fields.MovieRuntime, genres []string) (*models.Movie, error) {
-	ret := _m.Called(ctx, title, year, runtime, genres)
+// GetRevision provides a mock function with given fields: ctx, movieID, version
+func (_m *MoviesStorage) GetRevision(ctx context.Context, movieID int64, version uint) (*models.MovieRevision, error) {
+	ret := _m.Called(ctx, movieID, version)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetRevision")
+	}
+
+	var r0 *models.MovieRevision
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64, uint) (*models.MovieRevision, error)); ok {
+		return rf(ctx, movieID, version)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64, uint) *models.MovieRevision); ok {
+		r0 = rf(ctx, movieID, version)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.MovieRevision)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64, uint) error); ok {
+		r1 = rf(ctx, movieID, version)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Insert provides a mock function with given fields: ctx, title, year, runtime, genres, userID
+func (_m *MoviesStorage) Insert(ctx context.Context, title string, year int32, runtime fields.MovieRuntime, genres []string, userID int64) (*models.Movie, error) {
+	ret := _m.Called(ctx, title, year, runtime, genres, userID)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Insert")
@@ -75,19 +125,49 @@ func (_m *MoviesStorage) Insert(ctx context.Context, title string, year int32, r
 
 	var r0 *models.Movie
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, int32, fields.MovieRuntime, []string) (*models.Movie, error)); ok {
-		return rf(ctx, title, year, runtime, genres)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int32, fields.MovieRuntime, []string, int64) (*models.Movie, error)); ok {
+		return rf(ctx, title, year, runtime, genres, userID)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, int32, fields.MovieRuntime, []string) *models.Movie); ok {
-		r0 = rf(ctx, title, year, runtime, genres)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int32, fields.MovieRuntime, []string, int64) *models.Movie); ok {
+		r0 = rf(ctx, title, year, runtime, genres, userID)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*models.Movie)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, int32, fields.MovieRuntime, []string) error); ok {
-		r1 = rf(ctx, title, year, runtime, genres)
+	if rf, ok := ret.Get(1).(func(context.Context, string, int32, fields.MovieRuntime, []string, int64) error); ok {
+		r1 = rf(ctx, title, year, runtime, genres, userID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InsertMany provides a mock function with given fields: ctx, _a1, dryRun
+func (_m *MoviesStorage) InsertMany(ctx context.Context, _a1 []*models.Movie, dryRun bool) ([]error, error) {
+	ret := _m.Called(ctx, _a1, dryRun)
+
+	if len(ret) == 0 {
+		panic("no return value specified for InsertMany")
+	}
+
+	var r0 []error
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []*models.Movie, bool) ([]error, error)); ok {
+		return rf(ctx, _a1, dryRun)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []*models.Movie, bool) []error); ok {
+		r0 = rf(ctx, _a1, dryRun)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]error)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []*models.Movie, bool) error); ok {
+		r1 = rf(ctx, _a1, dryRun)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -95,9 +175,9 @@ func (_m *MoviesStorage) Insert(ctx context.Context, title string, year int32, r
 	return r0, r1
 }
 
-// List provides a mock function with given fields: ctx, title, genres, _a3
-func (_m *MoviesStorage) List(ctx context.Context, title string, genres []string, _a3 filters.Filters) ([]models.Movie, int, error) {
-	ret := _m.Called(ctx, title, genres, _a3)
+// List provides a mock function with given fields: ctx, movieFilters, _a2, _a3
+func (_m *MoviesStorage) List(ctx context.Context, movieFilters filters.MovieFilters, _a2 filters.Filters, _a3 []string) ([]models.Movie, int, error) {
+	ret := _m.Called(ctx, movieFilters, _a2, _a3)
 
 	if len(ret) == 0 {
 		panic("no return value specified for List")
@@ -106,25 +186,210 @@ func (_m *MoviesStorage) List(ctx context.Context, title string, genres []string
 	var r0 []models.Movie
 	var r1 int
 	var r2 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, []string, filters.Filters) ([]models.Movie, int, error)); ok {
-		return rf(ctx, title, genres, _a3)
+	if rf, ok := ret.Get(0).(func(context.Context, filters.MovieFilters, filters.Filters, []string) ([]models.Movie, int, error)); ok {
+		return rf(ctx, movieFilters, _a2, _a3)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, []string, filters.Filters) []models.Movie); ok {
-		r0 = rf(ctx, title, genres, _a3)
+	if rf, ok := ret.Get(0).(func(context.Context, filters.MovieFilters, filters.Filters, []string) []models.Movie); ok {
+		r0 = rf(ctx, movieFilters, _a2, _a3)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]models.Movie)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, []string, filters.Filters) int); ok {
-		r1 = rf(ctx, title, genres, _a3)
+	if rf, ok := ret.Get(1).(func(context.Context, filters.MovieFilters, filters.Filters, []string) int); ok {
+		r1 = rf(ctx, movieFilters, _a2, _a3)
+	} else {
+		r1 = ret.Get(1).(int)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, filters.MovieFilters, filters.Filters, []string) error); ok {
+		r2 = rf(ctx, movieFilters, _a2, _a3)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ListByCursor provides a mock function with given fields: ctx, movieFilters, _a2, _a3
+func (_m *MoviesStorage) ListByCursor(ctx context.Context, movieFilters filters.MovieFilters, _a2 filters.Filters, _a3 []string) ([]models.Movie, error) {
+	ret := _m.Called(ctx, movieFilters, _a2, _a3)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListByCursor")
+	}
+
+	var r0 []models.Movie
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, filters.MovieFilters, filters.Filters, []string) ([]models.Movie, error)); ok {
+		return rf(ctx, movieFilters, _a2, _a3)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, filters.MovieFilters, filters.Filters, []string) []models.Movie); ok {
+		r0 = rf(ctx, movieFilters, _a2, _a3)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Movie)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, filters.MovieFilters, filters.Filters, []string) error); ok {
+		r1 = rf(ctx, movieFilters, _a2, _a3)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListRevisions provides a mock function with given fields: ctx, movieID
+func (_m *MoviesStorage) ListRevisions(ctx context.Context, movieID int64) ([]models.MovieRevision, error) {
+	ret := _m.Called(ctx, movieID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListRevisions")
+	}
+
+	var r0 []models.MovieRevision
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int64) ([]models.MovieRevision, error)); ok {
+		return rf(ctx, movieID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int64) []models.MovieRevision); ok {
+		r0 = rf(ctx, movieID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.MovieRevision)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int64) error); ok {
+		r1 = rf(ctx, movieID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeTrash provides a mock function with given fields: ctx, deletedBefore
+func (_m *MoviesStorage) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	ret := _m.Called(ctx, deletedBefore)
+
+	if len(ret) == 0 {
+		panic("no return value specified for PurgeTrash")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int64, error)); ok {
+		return rf(ctx, deletedBefore)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int64); ok {
+		r0 = rf(ctx, deletedBefore)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, deletedBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RatingSummaries provides a mock function with given fields: ctx, movieIDs
+func (_m *MoviesStorage) RatingSummaries(ctx context.Context, movieIDs []int64) (map[int64]models.RatingSummary, error) {
+	ret := _m.Called(ctx, movieIDs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RatingSummaries")
+	}
+
+	var r0 map[int64]models.RatingSummary
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []int64) (map[int64]models.RatingSummary, error)); ok {
+		return rf(ctx, movieIDs)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []int64) map[int64]models.RatingSummary); ok {
+		r0 = rf(ctx, movieIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[int64]models.RatingSummary)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []int64) error); ok {
+		r1 = rf(ctx, movieIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Restore provides a mock function with given fields: ctx, id
+func (_m *MoviesStorage) Restore(ctx context.Context, id int) (*models.Movie, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Restore")
+	}
+
+	var r0 *models.Movie
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int) (*models.Movie, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int) *models.Movie); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Movie)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Search provides a mock function with given fields: ctx, q, movieFilters, _a3, _a4
+func (_m *MoviesStorage) Search(ctx context.Context, q string, movieFilters filters.MovieFilters, _a3 filters.Filters, _a4 []string) ([]models.MovieSearchHit, int, error) {
+	ret := _m.Called(ctx, q, movieFilters, _a3, _a4)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Search")
+	}
+
+	var r0 []models.MovieSearchHit
+	var r1 int
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, filters.MovieFilters, filters.Filters, []string) ([]models.MovieSearchHit, int, error)); ok {
+		return rf(ctx, q, movieFilters, _a3, _a4)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, filters.MovieFilters, filters.Filters, []string) []models.MovieSearchHit); ok {
+		r0 = rf(ctx, q, movieFilters, _a3, _a4)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.MovieSearchHit)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, filters.MovieFilters, filters.Filters, []string) int); ok {
+		r1 = rf(ctx, q, movieFilters, _a3, _a4)
 	} else {
 		r1 = ret.Get(1).(int)
 	}
 
-	if rf, ok := ret.Get(2).(func(context.Context, string, []string, filters.Filters) error); ok {
-		r2 = rf(ctx, title, genres, _a3)
+	if rf, ok := ret.Get(2).(func(context.Context, string, filters.MovieFilters, filters.Filters, []string) error); ok {
+		r2 = rf(ctx, q, movieFilters, _a3, _a4)
 	} else {
 		r2 = ret.Error(2)
 	}
@@ -132,9 +397,9 @@ func (_m *MoviesStorage) List(ctx context.Context, title string, genres []string
 	return r0, r1, r2
 }
 
-// Update provides a mock function with given fields: ctx, movie
-func (_m *MoviesStorage) Update(ctx context.Context, movie *models.Movie) (*models.Movie, error) {
-	ret := _m.Called(ctx, movie)
+// Update provides a mock function with given fields: ctx, movie, userID, changes
+func (_m *MoviesStorage) Update(ctx context.Context, movie *models.Movie, userID int64, changes map[string]models.FieldChange) (*models.Movie, error) {
+	ret := _m.Called(ctx, movie, userID, changes)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Update")
@@ -142,19 +407,19 @@ func (_m *MoviesStorage) Update(ctx context.Context, movie *models.Movie) (*mode
 
 	var r0 *models.Movie
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, *models.Movie) (*models.Movie, error)); ok {
-		return rf(ctx, movie)
+	if rf, ok := ret.Get(0).(func(context.Context, *models.Movie, int64, map[string]models.FieldChange) (*models.Movie, error)); ok {
+		return rf(ctx, movie, userID, changes)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, *models.Movie) *models.Movie); ok {
-		r0 = rf(ctx, movie)
+	if rf, ok := ret.Get(0).(func(context.Context, *models.Movie, int64, map[string]models.FieldChange) *models.Movie); ok {
+		r0 = rf(ctx, movie, userID, changes)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(*models.Movie)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, *models.Movie) error); ok {
-		r1 = rf(ctx, movie)
+	if rf, ok := ret.Get(1).(func(context.Context, *models.Movie, int64, map[string]models.FieldChange) error); ok {
+		r1 = rf(ctx, movie, userID, changes)
 	} else {
 		r1 = ret.Error(1)
 	}
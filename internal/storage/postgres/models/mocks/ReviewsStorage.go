@@ -44,6 +44,36 @@ func (_m *ReviewsStorage) GetForMovie(ctx context.Context, movieID int64) ([]mod
 	return r0, r1
 }
 
+// GetForMovies provides a mock function with given fields: ctx, movieIDs
+func (_m *ReviewsStorage) GetForMovies(ctx context.Context, movieIDs []int64) (map[int64][]models.Review, error) {
+	ret := _m.Called(ctx, movieIDs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetForMovies")
+	}
+
+	var r0 map[int64][]models.Review
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []int64) (map[int64][]models.Review, error)); ok {
+		return rf(ctx, movieIDs)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []int64) map[int64][]models.Review); ok {
+		r0 = rf(ctx, movieIDs)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(map[int64][]models.Review)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []int64) error); ok {
+		r1 = rf(ctx, movieIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewReviewsStorage creates a new instance of ReviewsStorage. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewReviewsStorage(t interface {
@@ -3,13 +3,23 @@ package models
 import "greenlight/proj/internal/storage/postgres"
 
 type Models struct {
-	Movie  *MovieModel
-	Review *ReviewModel
+	Movie      *MovieModel
+	Review     *ReviewModel
+	Reply      *ReplyModel
+	ApiKey     *ApiKeyModel
+	Token      *TokenModel
+	Permission *PermissionModel
+	Account    *AccountModel
 }
 
 func New(db *postgres.Storage) *Models {
 	return &Models{
-		Movie:  &MovieModel{db.Conn},
-		Review: &ReviewModel{db.Conn},
+		Movie:      &MovieModel{db.Conn},
+		Review:     &ReviewModel{db.Conn},
+		Reply:      &ReplyModel{db.Conn},
+		ApiKey:     &ApiKeyModel{db.Conn},
+		Token:      &TokenModel{db.Conn},
+		Permission: &PermissionModel{db.Conn},
+		Account:    &AccountModel{db.Conn},
 	}
 }
@@ -9,6 +9,10 @@ import (
 	"greenlight/proj/internal/domain/models"
 	"greenlight/proj/internal/storage"
 	"greenlight/proj/internal/storage/postgres"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
@@ -19,16 +23,52 @@ type MovieModel struct {
 	DB *pgxpool.Pool
 }
 
-func (m *MovieModel) Get(ctx context.Context, id int) (*models.Movie, error) {
+// movieProjectionColumns maps movie fields, which can be requested in the sparse fieldset, to columns
+var movieProjectionColumns = map[string]string{
+	"id":             "id",
+	"title":          "title",
+	"year":           "year",
+	"runtime":        "runtime",
+	"genres":         "genres",
+	"version":        "version",
+	"user_id":        "user_id",
+	"deleted_at":     "deleted_at",
+	"average_rating": "average_rating",
+	"reviews_count":  "reviews_count",
+}
+
+const movieColumns = "id, title, year, runtime, genres, version, created_at, user_id, deleted_at, average_rating, reviews_count"
+
+// movieSelectColumns returns columns for the movie fields, all of them if fields are empty.
+// ID and version are always selected, because they identify the movie state (e.g. for cursors and ETags)
+func movieSelectColumns(fields []string) string {
+	if len(fields) == 0 {
+		return movieColumns
+	}
+	columns := []string{"id", "version"}
+	for _, field := range fields {
+		column, ok := movieProjectionColumns[field]
+		if !ok {
+			panic(errors.New("Unknown projection field: " + field))
+		}
+		if !slices.Contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return strings.Join(columns, ", ")
+}
+
+// Get selects the movie with the fields only, all of them if fields are empty
+func (m *MovieModel) Get(ctx context.Context, id int, fields []string) (*models.Movie, error) {
 	rows, err := m.DB.Query(
 		ctx,
-		`SELECT id, title, year, runtime, genres, version, created_at FROM movies WHERE id = $1`,
+		fmt.Sprintf(`SELECT %s FROM movies WHERE id = $1 AND deleted_at IS NULL`, movieSelectColumns(fields)),
 		id,
 	)
 	if err != nil {
 		return nil, err
 	}
-	movie, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Movie])
+	movie, err := pgx.CollectOneRow(rows, pgx.RowToStructByNameLax[models.Movie])
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, storage.ErrNotFound
@@ -38,14 +78,24 @@ func (m *MovieModel) Get(ctx context.Context, id int) (*models.Movie, error) {
 	return &movie, nil
 }
 
-func (m *MovieModel) Insert(ctx context.Context, title string, year int32, runtime fields.MovieRuntime, genres []string) (*models.Movie, error) {
+func (m *MovieModel) Insert(ctx context.Context, title string, year int32, runtime fields.MovieRuntime, genres []string, userID int64) (*models.Movie, error) {
+	snapshot := models.MovieSnapshot{Title: title, Year: year, Runtime: runtime, Genres: genres}
 	rows, _ := m.DB.Query(
 		ctx,
-		"INSERT INTO movies (title, year, runtime, genres) VALUES ($1, $2, $3, $4) RETURNING *",
+		`WITH inserted AS (
+			INSERT INTO movies (title, year, runtime, genres, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING `+movieColumns+`
+		), revision AS (
+			INSERT INTO movie_revisions (movie_id, version, user_id, changes, snapshot)
+			SELECT id, version, user_id, $6, $7 FROM inserted
+		)
+		SELECT * FROM inserted`,
 		title,
 		year,
 		runtime,
 		genres,
+		userID,
+		models.DiffMovieSnapshots(nil, snapshot),
+		snapshot,
 	)
 	movie, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Movie])
 	if err != nil {
@@ -58,22 +108,133 @@ func (m *MovieModel) Insert(ctx context.Context, title string, year int32, runti
 	return &movie, nil
 }
 
-func (m *MovieModel) List(ctx context.Context, title string, genres []string, filters filters.Filters) ([]models.Movie, int, error) {
+// insertManyBatchSize limits the number of queries sent to the db in a single batch by InsertMany
+const insertManyBatchSize = 500
+
+// InsertMany inserts movies in a single transaction using batches of queries.
+// Inserted movies are filled in place with generated fields. Duplicates don't abort the rest of the movies,
+// instead storage.ErrConflict is returned in errs at the index of duplicated movie.
+// As all the movies share the transaction, duplicates within movies themselves are detected as well.
+// If dryRun is true the transaction is rolled back, so nothing is actually inserted
+func (m *MovieModel) InsertMany(ctx context.Context, movies []*models.Movie, dryRun bool) (errs []error, err error) {
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+	errs = make([]error, len(movies))
+	for start := 0; start < len(movies); start += insertManyBatchSize {
+		end := min(start+insertManyBatchSize, len(movies))
+		if err := insertMoviesBatch(ctx, tx, movies[start:end], errs[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		return errs, nil
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
+// insertMoviesBatch sends inserts of the movies to the db in a single batch, recording duplicates in errs
+func insertMoviesBatch(ctx context.Context, tx pgx.Tx, movies []*models.Movie, errs []error) error {
+	batch := &pgx.Batch{}
+	for _, movie := range movies {
+		snapshot := movie.Snapshot()
+		batch.Queue(
+			`WITH inserted AS (
+				INSERT INTO movies (title, year, runtime, genres, user_id) VALUES ($1, $2, $3, $4, $5)
+				ON CONFLICT DO NOTHING RETURNING id, version, created_at, user_id
+			), revision AS (
+				INSERT INTO movie_revisions (movie_id, version, user_id, changes, snapshot)
+				SELECT id, version, user_id, $6, $7 FROM inserted
+			)
+			SELECT id, version, created_at FROM inserted`,
+			movie.Title,
+			movie.Year,
+			movie.Runtime,
+			movie.Genres,
+			movie.UserID,
+			models.DiffMovieSnapshots(nil, snapshot),
+			snapshot,
+		)
+	}
+	results := tx.SendBatch(ctx, batch)
+	for i, movie := range movies {
+		err := results.QueryRow().Scan(&movie.ID, &movie.Version, &movie.CreatedAt)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				errs[i] = storage.ErrConflict
+				continue
+			}
+			results.Close()
+			return err
+		}
+	}
+	return results.Close()
+}
+
+// movieFilterColumns maps fields, which movies can be filtered by, to sql expressions
+var movieFilterColumns = map[string]string{
+	"year":       "year",
+	"runtime":    "runtime",
+	"created_at": "created_at",
+	"rating":     "NULLIF(average_rating, 0)", // movies without reviews have no rating rather than 0
+}
+
+// movieFiltersSQL builds WHERE clause for the movie filters.
+// Filter values are appended to args and referenced by placeholders numbered accordingly
+func movieFiltersSQL(movieFilters filters.MovieFilters, args []any) (string, []any) {
+	args = append(args, movieFilters.Title, movieFilters.Genres)
+	titleArg, genresArg := len(args)-1, len(args)
+	deletedCondition := "deleted_at IS NULL"
+	if movieFilters.Deleted {
+		deletedCondition = "deleted_at IS NOT NULL"
+	}
+	conditions := []string{
+		deletedCondition,
+		fmt.Sprintf("(to_tsvector('english', title) @@ plainto_tsquery('english', $%[1]d) OR $%[1]d = '')", titleArg),
+		fmt.Sprintf("(genres @> $%[1]d OR $%[1]d = '{}')", genresArg),
+	}
+	if movieFilters.UserID != 0 {
+		args = append(args, movieFilters.UserID)
+		conditions = append(conditions, fmt.Sprintf("user_id = $%d", len(args)))
+	}
+	for _, condition := range movieFilters.Conditions {
+		column, ok := movieFilterColumns[condition.Field]
+		if !ok {
+			panic(errors.New("Unknown filter field: " + condition.Field))
+		}
+		switch condition.Operator {
+		case filters.OpEq, filters.OpGt, filters.OpGte, filters.OpLt, filters.OpLte:
+		default:
+			panic(errors.New("Unknown filter operator: " + string(condition.Operator)))
+		}
+		args = append(args, condition.Value)
+		conditions = append(conditions, fmt.Sprintf("%s %s $%d", column, condition.Operator, len(args)))
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// List selects the page of movies with the fields only, all of them if fields are empty
+func (m *MovieModel) List(ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, fields []string) ([]models.Movie, int, error) {
 	var rows pgx.Rows
+	where, args := movieFiltersSQL(movieFilters, nil)
 	query := fmt.Sprintf(`
-	SELECT count(*) OVER(), id, title, year, runtime, genres, version, created_at FROM movies
-	WHERE (to_tsvector('english', title) @@ plainto_tsquery('english', $1) OR $1 = '') 
-	AND (genres @> $2 OR $2 = '{}')
+	SELECT count(*) OVER(), %s FROM movies
+	%s
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4
-	`, filters.SortColumn(), filters.SortDirection())
-	args := []any{title, genres, filters.Limit(), filters.Offset()}
+	LIMIT $%d OFFSET $%d
+	`, movieSelectColumns(fields), where, filters.SortColumn(), filters.SortDirection(), len(args)+1, len(args)+2)
+	args = append(args, filters.Limit(), filters.Offset())
 	rows, _ = m.DB.Query(ctx, query, args...)
 	type row struct {
 		Count int
 		models.Movie
 	}
-	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[row])
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, 0, storage.ErrNotFound
@@ -84,21 +245,190 @@ func (m *MovieModel) List(ctx context.Context, title string, genres []string, fi
 	for _, row := range outputRows {
 		movies = append(movies, row.Movie)
 	}
+	if len(outputRows) == 0 {
+		return movies, 0, nil
+	}
 	totalRecords := outputRows[0].Count
 	return movies, totalRecords, nil
 }
 
-func (m *MovieModel) Update(ctx context.Context, movie *models.Movie) (*models.Movie, error) {
+// prefixTSQuery builds tsquery matching all the words of the search query as prefixes (e.g. "star wa" finds "Star Wars").
+// Only letters and digits are kept, so the user input can't inject tsquery operators
+func prefixTSQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+	terms := make([]string, 0, len(words))
+	for _, word := range words {
+		terms = append(terms, "'"+word+"':*")
+	}
+	return strings.Join(terms, " & ")
+}
+
+// Search selects the page of movies whose title matches the query ordered by relevance.
+// Full text matches come first ordered by rank, then titles similar to the query (e.g. misspelled ones) ordered by similarity
+func (m *MovieModel) Search(
+	ctx context.Context, q string, movieFilters filters.MovieFilters, filters filters.Filters, fields []string,
+) ([]models.MovieSearchHit, int, error) {
+	where, args := movieFiltersSQL(movieFilters, []any{prefixTSQuery(q), q, filters.Limit(), filters.Offset()})
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), %s,
+		ts_rank(to_tsvector('english', title), search.tsq) AS rank,
+		word_similarity($2, title) AS similarity,
+		ts_headline(
+			'english', replace(replace(replace(title, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), search.tsq,
+			'StartSel=<mark>, StopSel=</mark>, HighlightAll=true'
+		) AS highlight
+	FROM movies, (SELECT to_tsquery('english', $1) AS tsq) AS search
+	%s
+	AND (to_tsvector('english', title) @@ search.tsq OR $2 <%% title)
+	ORDER BY to_tsvector('english', title) @@ search.tsq DESC, rank DESC, similarity DESC, id ASC
+	LIMIT $3 OFFSET $4
+	`, movieSelectColumns(fields), where)
+	rows, _ := m.DB.Query(ctx, query, args...)
+	type row struct {
+		Count int
+		models.MovieSearchHit
+	}
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[row])
+	if err != nil {
+		return nil, 0, err
+	}
+	hits := make([]models.MovieSearchHit, 0, len(outputRows))
+	for _, row := range outputRows {
+		hits = append(hits, row.MovieSearchHit)
+	}
+	if len(outputRows) == 0 {
+		return hits, 0, nil
+	}
+	return hits, outputRows[0].Count, nil
+}
+
+// ListByCursor selects the page of movies located after (or before for backward pages) the movie from filters cursor.
+// Rows are returned in the read direction and the page includes one extra row if there are more rows beyond it.
+// The sort column is always selected, so cursors can be made of the rows
+func (m *MovieModel) ListByCursor(ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, fields []string) ([]models.Movie, error) {
+	keyset, args, err := movieKeysetSQL(filters, []any{filters.KeysetLimit()})
+	if err != nil {
+		return nil, err
+	}
+	where, args := movieFiltersSQL(movieFilters, args)
+	sortColumn := filters.SortColumn()
+	columns := movieSelectColumns(fields)
+	if len(fields) > 0 && !slices.Contains(strings.Split(columns, ", "), sortColumn) {
+		columns += ", " + sortColumn
+	}
+	query := fmt.Sprintf(`
+	SELECT %[5]s FROM movies
+	%[4]s
+	AND %[2]s
+	ORDER BY %[1]s %[3]s, id %[3]s
+	LIMIT $1
+	`, sortColumn, keyset, filters.KeysetDirection(), where, columns)
+	rows, _ := m.DB.Query(ctx, query, args...)
+	movies, err := pgx.CollectRows(rows, pgx.RowToStructByNameLax[models.Movie])
+	if err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+// movieKeysetSQL builds the condition selecting rows after the filters cursor in the read direction.
+// Rows are compared to the sort value and id stored in the cursor. Postgres orders NULLs last ascending
+// and first descending, so they are compared separately as the row comparison doesn't handle them
+func movieKeysetSQL(filters filters.Filters, args []any) (string, []any, error) {
+	if filters.Cursor == nil {
+		return "TRUE", args, nil
+	}
+	value, err := filters.CursorValue(models.Movie{})
+	if err != nil {
+		return "", nil, err
+	}
+	column := filters.SortColumn()
+	args = append(args, filters.CursorID())
+	idArg := len(args)
+	ascending := filters.KeysetOperator() == ">"
+	if value == nil {
+		if ascending {
+			return fmt.Sprintf("(%s IS NULL AND id > $%d)", column, idArg), args, nil
+		}
+		return fmt.Sprintf("(%s IS NOT NULL OR id < $%d)", column, idArg), args, nil
+	}
+	args = append(args, value)
+	valueArg := len(args)
+	if ascending {
+		return fmt.Sprintf("((%[1]s, id) > ($%[2]d, $%[3]d) OR %[1]s IS NULL)", column, valueArg, idArg), args, nil
+	}
+	return fmt.Sprintf("(%s, id) < ($%d, $%d)", column, valueArg, idArg), args, nil
+}
+
+// Export streams movies matching the filters ordered by filters sort, calling fn for each of them.
+// Rows are read from the connection as they are consumed, so the whole result is never kept in memory.
+// If withReviews is true, reviews of each movie are aggregated into the same row
+func (m *MovieModel) Export(
+	ctx context.Context, movieFilters filters.MovieFilters, filters filters.Filters, withReviews bool, fn func(*models.Movie) error,
+) error {
+	where, args := movieFiltersSQL(movieFilters, nil)
+	reviewsColumn := "'[]'::json"
+	if withReviews {
+		reviewsColumn = `COALESCE((
+			SELECT json_agg(json_build_object(
+				'id', r.id, 'movie_id', r.movie_id, 'user_id', r.user_id, 'comment', r.comment, 'rating', r.rating,
+				'created_at', r.created_at, 'updated_at', r.updated_at, 'status', r.status,
+				'helpful_votes', r.helpful_votes, 'unhelpful_votes', r.unhelpful_votes, 'helpful_score', r.helpful_score,
+				'replies_count', r.replies_count
+			) ORDER BY r.helpful_score DESC, r.id) FROM reviews r
+			WHERE r.movie_id = movies.id AND r.status = 'approved' AND r.deleted_at IS NULL
+		), '[]'::json)`
+	}
+	query := fmt.Sprintf(`
+	SELECT %s, %s AS reviews FROM movies
+	%s
+	ORDER BY %s %s, id ASC
+	`, movieColumns, reviewsColumn, where, filters.SortColumn(), filters.SortDirection())
+	rows, err := m.DB.Query(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	type row struct {
+		models.Movie
+		MovieReviews []models.Review `db:"reviews"`
+	}
+	for rows.Next() {
+		exported, err := pgx.RowToStructByName[row](rows)
+		if err != nil {
+			return err
+		}
+		if withReviews {
+			exported.Movie.Reviews = exported.MovieReviews
+		}
+		if err := fn(&exported.Movie); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Update saves the movie if its version wasn't changed since it was read and records the change as a new revision
+func (m *MovieModel) Update(ctx context.Context, movie *models.Movie, userID int64, changes map[string]models.FieldChange) (*models.Movie, error) {
 	rows, _ := m.DB.Query(
 		ctx,
-		`UPDATE movies SET version = version + 1, title = $1, year = $2, runtime = $3, genres = $4
-		WHERE id = $5 AND version = $6 RETURNING *`,
+		`WITH updated AS (
+			UPDATE movies SET version = version + 1, title = $1, year = $2, runtime = $3, genres = $4
+			WHERE id = $5 AND version = $6 AND deleted_at IS NULL RETURNING `+movieColumns+`
+		), revision AS (
+			INSERT INTO movie_revisions (movie_id, version, user_id, changes, snapshot)
+			SELECT id, version, $7, $8, $9 FROM updated
+		)
+		SELECT * FROM updated`,
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
 		movie.Genres,
 		movie.ID,
 		movie.Version,
+		userID,
+		changes,
+		movie.Snapshot(),
 	)
 	updatedMovie, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Movie])
 	if err != nil {
@@ -114,13 +444,106 @@ func (m *MovieModel) Update(ctx context.Context, movie *models.Movie) (*models.M
 	return &updatedMovie, nil
 }
 
-func (m *MovieModel) Delete(ctx context.Context, id int) error {
-	status, err := m.DB.Exec(ctx, "DELETE FROM movies WHERE id = $1", id)
-	if status.RowsAffected() == 0 {
-		return storage.ErrNotFound
+// ListRevisions returns revisions of the movie starting from the latest one
+func (m *MovieModel) ListRevisions(ctx context.Context, movieID int64) ([]models.MovieRevision, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT id, movie_id, version, user_id, changes, snapshot, created_at FROM movie_revisions
+		WHERE movie_id = $1 ORDER BY version DESC`,
+		movieID,
+	)
+	revisions, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.MovieRevision])
+	if err != nil {
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (m *MovieModel) GetRevision(ctx context.Context, movieID int64, version uint) (*models.MovieRevision, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT id, movie_id, version, user_id, changes, snapshot, created_at FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`,
+		movieID,
+		version,
+	)
+	revision, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.MovieRevision])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
 	}
+	return &revision, nil
+}
+
+// Delete moves the movie to the trash. It's kept there alongside with its reviews until restored or purged.
+// If version isn't zero, the movie is deleted only if it has that version
+func (m *MovieModel) Delete(ctx context.Context, id int, version uint) error {
+	status, err := m.DB.Exec(
+		ctx,
+		"UPDATE movies SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2)",
+		id,
+		version,
+	)
 	if err != nil {
 		return err
 	}
+	if status.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
 	return nil
 }
+
+// Restore moves the movie back from the trash. Returns storage.ErrConflict if the same movie has been created since
+func (m *MovieModel) Restore(ctx context.Context, id int) (*models.Movie, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		"UPDATE movies SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL RETURNING "+movieColumns,
+		id,
+	)
+	movie, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Movie])
+	if err != nil {
+		var pgxErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgxErr) && pgxErr.Code == postgres.ErrConflictCode:
+			return nil, storage.ErrConflict
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &movie, nil
+}
+
+// PurgeTrash permanently deletes movies moved to the trash before the specified time.
+// Reviews and revisions of the movies are deleted in cascade
+func (m *MovieModel) PurgeTrash(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	status, err := m.DB.Exec(ctx, "DELETE FROM movies WHERE deleted_at < $1", deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return status.RowsAffected(), nil
+}
+
+// RatingSummaries returns rating stats of the movies. Stats are maintained by the trigger on reviews
+func (m *MovieModel) RatingSummaries(ctx context.Context, movieIDs []int64) (map[int64]models.RatingSummary, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT id, average_rating, reviews_count, rating_histogram AS histogram FROM movies WHERE id = ANY($1)`,
+		movieIDs,
+	)
+	type row struct {
+		ID int64
+		models.RatingSummary
+	}
+	summaryRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		return nil, err
+	}
+	summaries := make(map[int64]models.RatingSummary, len(summaryRows))
+	for _, row := range summaryRows {
+		summaries[row.ID] = row.RatingSummary
+	}
+	return summaries, nil
+}
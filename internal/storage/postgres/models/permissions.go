@@ -0,0 +1,144 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/storage"
+	"greenlight/proj/internal/storage/postgres"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PermissionModel struct {
+	DB *pgxpool.Pool
+}
+
+// InsertPermission registers the permission code. Returns storage.ErrConflict if it's registered already
+func (m *PermissionModel) InsertPermission(ctx context.Context, code string) error {
+	_, err := m.DB.Exec(ctx, "INSERT INTO permissions (code) VALUES ($1)", code)
+	if err != nil {
+		var pgxErr *pgconn.PgError
+		if errors.As(err, &pgxErr) && pgxErr.Code == postgres.ErrConflictCode {
+			return storage.ErrConflict
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *PermissionModel) ListPermissions(ctx context.Context) ([]string, error) {
+	rows, _ := m.DB.Query(ctx, "SELECT code FROM permissions ORDER BY code")
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// PermissionExists reports whether the permission code is registered
+func (m *PermissionModel) PermissionExists(ctx context.Context, code string) (bool, error) {
+	var exists bool
+	err := m.DB.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM permissions WHERE code = $1)", code).Scan(&exists)
+	return exists, err
+}
+
+// RevokeUserPermission records the revocation, it overrides the grant stored in SSO
+func (m *PermissionModel) RevokeUserPermission(ctx context.Context, userID int64, code string, revokedBy int64) error {
+	_, err := m.DB.Exec(
+		ctx,
+		`INSERT INTO permission_revocations (user_id, code, revoked_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, code) DO NOTHING`,
+		userID,
+		code,
+		revokedBy,
+	)
+	return err
+}
+
+// DeleteUserPermissionRevocations deletes the revocations of the codes, so the grants stored in SSO are in effect again
+func (m *PermissionModel) DeleteUserPermissionRevocations(ctx context.Context, userID int64, codes []string) error {
+	_, err := m.DB.Exec(ctx, "DELETE FROM permission_revocations WHERE user_id = $1 AND code = ANY($2)", userID, codes)
+	return err
+}
+
+func (m *PermissionModel) IsUserPermissionRevoked(ctx context.Context, userID int64, code string) (bool, error) {
+	var revoked bool
+	err := m.DB.QueryRow(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM permission_revocations WHERE user_id = $1 AND code = $2)",
+		userID,
+		code,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// ListUserPermissionRevocations selects the codes revoked from the user
+func (m *PermissionModel) ListUserPermissionRevocations(ctx context.Context, userID int64) ([]string, error) {
+	rows, _ := m.DB.Query(ctx, "SELECT code FROM permission_revocations WHERE user_id = $1 ORDER BY code", userID)
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
+
+// GetUserRole returns storage.ErrNotFound if the user has no role assigned yet
+func (m *PermissionModel) GetUserRole(ctx context.Context, userID int64) (string, error) {
+	var role string
+	err := m.DB.QueryRow(ctx, "SELECT role FROM user_roles WHERE user_id = $1", userID).Scan(&role)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", storage.ErrNotFound
+		}
+		return "", err
+	}
+	return role, nil
+}
+
+func (m *PermissionModel) SetUserRole(ctx context.Context, userID int64, role string, assignedBy int64) error {
+	_, err := m.DB.Exec(
+		ctx,
+		`INSERT INTO user_roles (user_id, role, assigned_by) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET role = EXCLUDED.role, assigned_by = EXCLUDED.assigned_by, updated_at = NOW()`,
+		userID,
+		role,
+		assignedBy,
+	)
+	return err
+}
+
+// ListRoleUsers selects IDs of the users with the role
+func (m *PermissionModel) ListRoleUsers(ctx context.Context, role string) ([]int64, error) {
+	rows, _ := m.DB.Query(ctx, "SELECT user_id FROM user_roles WHERE role = $1 ORDER BY user_id", role)
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+// GetRoleDefinitions selects the definitions the users have been synced with
+func (m *PermissionModel) GetRoleDefinitions(ctx context.Context) (map[string][]string, error) {
+	rows, _ := m.DB.Query(ctx, "SELECT role, permissions FROM role_definitions")
+	type row struct {
+		Role        string
+		Permissions []string
+	}
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string][]string, len(outputRows))
+	for _, row := range outputRows {
+		definitions[row.Role] = row.Permissions
+	}
+	return definitions, nil
+}
+
+// SaveRoleDefinitions replaces the stored definitions in a single transaction
+func (m *PermissionModel) SaveRoleDefinitions(ctx context.Context, definitions map[string][]string) error {
+	tx, err := m.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "DELETE FROM role_definitions"); err != nil {
+		return err
+	}
+	for role, permissions := range definitions {
+		if _, err := tx.Exec(ctx, "INSERT INTO role_definitions (role, permissions) VALUES ($1, $2)", role, permissions); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/filters"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type ReplyModel struct {
+	DB *pgxpool.Pool
+}
+
+// Insert saves the reply. Counters of the review and the parent reply are updated by the trigger
+func (m *ReplyModel) Insert(ctx context.Context, reviewID int64, parentID *int64, userID int64, body string, depth int) (*models.ReviewReply, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		"INSERT INTO review_replies (review_id, parent_id, user_id, body, depth) VALUES ($1, $2, $3, $4, $5) RETURNING *",
+		reviewID,
+		parentID,
+		userID,
+		body,
+		depth,
+	)
+	reply, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.ReviewReply])
+	if err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Get selects the reply, tombstones of the deleted replies are selected too
+func (m *ReplyModel) Get(ctx context.Context, id int64) (*models.ReviewReply, error) {
+	rows, _ := m.DB.Query(ctx, "SELECT * FROM review_replies WHERE id = $1", id)
+	reply, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.ReviewReply])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// List selects the page of direct replies to the parent reply, or to the review itself if parentID is nil.
+// Replies are ordered from the oldest, so the conversation reads top down
+func (m *ReplyModel) List(ctx context.Context, reviewID int64, parentID *int64, filters filters.Filters) ([]models.ReviewReply, int, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT count(*) OVER(), * FROM review_replies
+		WHERE review_id = $1 AND parent_id IS NOT DISTINCT FROM $2
+		ORDER BY created_at ASC, id ASC
+		LIMIT $3 OFFSET $4`,
+		reviewID,
+		parentID,
+		filters.Limit(),
+		filters.Offset(),
+	)
+	type row struct {
+		Count int
+		models.ReviewReply
+	}
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		return nil, 0, err
+	}
+	replies := make([]models.ReviewReply, 0, len(outputRows))
+	for _, row := range outputRows {
+		replies = append(replies, row.ReviewReply)
+	}
+	if len(outputRows) == 0 {
+		return replies, 0, nil
+	}
+	return replies, outputRows[0].Count, nil
+}
+
+// Update saves the body of the reply. Tombstones can't be updated, storage.ErrNotFound is returned for them
+func (m *ReplyModel) Update(ctx context.Context, id int64, body string) (*models.ReviewReply, error) {
+	rows, _ := m.DB.Query(ctx, "UPDATE review_replies SET body = $1 WHERE id = $2 AND deleted_at IS NULL RETURNING *", body, id)
+	reply, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.ReviewReply])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Delete deletes the reply. If the reply has replies of its own, it's kept as a tombstone without the body
+func (m *ReplyModel) Delete(ctx context.Context, id int64) error {
+	var affected int
+	err := m.DB.QueryRow(
+		ctx,
+		`WITH reply AS (
+			SELECT id, replies_count > 0 AS has_replies FROM review_replies WHERE id = $1 AND deleted_at IS NULL
+		), tombstoned AS (
+			UPDATE review_replies SET body = '', deleted_at = NOW() WHERE id IN (SELECT id FROM reply WHERE has_replies) RETURNING id
+		), deleted AS (
+			DELETE FROM review_replies WHERE id IN (SELECT id FROM reply WHERE NOT has_replies) RETURNING id
+		)
+		SELECT (SELECT count(*) FROM tombstoned) + (SELECT count(*) FROM deleted)`,
+		id,
+	).Scan(&affected)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package models
 import (
 	"context"
 	"errors"
+	"fmt"
+	"greenlight/proj/internal/domain/filters"
 	"greenlight/proj/internal/domain/models"
 	"greenlight/proj/internal/storage"
 	"greenlight/proj/internal/storage/postgres"
@@ -16,8 +18,25 @@ type ReviewModel struct {
 	DB *pgxpool.Pool
 }
 
-func (m *ReviewModel) Insert(ctx context.Context, rating int32, comment string, movieID int64, userID int64) (*models.Review, error) {
-	rows, _ := m.DB.Query(ctx, "INSERT INTO reviews (rating, comment, movie_id, user_id) VALUES ($1, $2, $3, $4) RETURNING *", rating, comment, movieID, userID)
+// Insert saves the review awaiting moderation. If flagReason is not empty, the review is flagged
+// on behalf of the application, so moderators see why it needs attention
+func (m *ReviewModel) Insert(ctx context.Context, rating int32, comment string, movieID int64, userID int64, flagReason string) (*models.Review, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`WITH inserted AS (
+			INSERT INTO reviews (rating, comment, movie_id, user_id, status)
+			VALUES ($1, $2, $3, $4, CASE WHEN $5 = '' THEN 'pending' ELSE 'flagged' END) RETURNING *
+		), flag AS (
+			INSERT INTO review_flags (review_id, user_id, reason) SELECT id, $6, $5 FROM inserted WHERE $5 <> ''
+		)
+		SELECT * FROM inserted`,
+		rating,
+		comment,
+		movieID,
+		userID,
+		flagReason,
+		models.SystemUserID,
+	)
 	review, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Review])
 	if err != nil {
 		var pgxErr *pgconn.PgError
@@ -29,8 +48,13 @@ func (m *ReviewModel) Insert(ctx context.Context, rating int32, comment string,
 	return &review, nil
 }
 
+// GetForMovie selects approved reviews of the movie, the most helpful ones first
 func (m *ReviewModel) GetForMovie(ctx context.Context, movieID int64) ([]models.Review, error) {
-	rows, _ := m.DB.Query(ctx, "SELECT * FROM reviews WHERE movie_id = $1", movieID)
+	rows, _ := m.DB.Query(
+		ctx,
+		"SELECT * FROM reviews WHERE movie_id = $1 AND status = 'approved' AND deleted_at IS NULL ORDER BY helpful_score DESC, id",
+		movieID,
+	)
 	reviews, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Review])
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -40,3 +64,263 @@ func (m *ReviewModel) GetForMovie(ctx context.Context, movieID int64) ([]models.
 	}
 	return reviews, nil
 }
+
+// GetForMovies selects approved reviews of all the movies in one query.
+// Reviews are grouped by movie ID, the most helpful ones first
+func (m *ReviewModel) GetForMovies(ctx context.Context, movieIDs []int64) (map[int64][]models.Review, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		"SELECT * FROM reviews WHERE movie_id = ANY($1) AND status = 'approved' AND deleted_at IS NULL ORDER BY helpful_score DESC, id",
+		movieIDs,
+	)
+	reviews, err := pgx.CollectRows(rows, pgx.RowToStructByName[models.Review])
+	if err != nil {
+		return nil, err
+	}
+	reviewsByMovie := make(map[int64][]models.Review, len(movieIDs))
+	for _, review := range reviews {
+		reviewsByMovie[review.MovieID] = append(reviewsByMovie[review.MovieID], review)
+	}
+	return reviewsByMovie, nil
+}
+
+// Get selects the review, tombstones of the deleted reviews are selected too
+func (m *ReviewModel) Get(ctx context.Context, id int64) (*models.Review, error) {
+	rows, _ := m.DB.Query(ctx, "SELECT * FROM reviews WHERE id = $1", id)
+	review, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Review])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &review, nil
+}
+
+// List selects the page of approved reviews of the movie. Returns storage.ErrNotFound if the movie doesn't exist or is in the trash
+func (m *ReviewModel) List(ctx context.Context, movieID int64, filters filters.Filters) ([]models.Review, int, error) {
+	var exists bool
+	err := m.DB.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1 AND deleted_at IS NULL)", movieID).Scan(&exists)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !exists {
+		return nil, 0, storage.ErrNotFound
+	}
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), * FROM reviews
+	WHERE movie_id = $1 AND status = 'approved' AND deleted_at IS NULL
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.SortColumn(), filters.SortDirection())
+	rows, _ := m.DB.Query(ctx, query, movieID, filters.Limit(), filters.Offset())
+	type row struct {
+		Count int
+		models.Review
+	}
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		return nil, 0, err
+	}
+	reviews := make([]models.Review, 0, len(outputRows))
+	for _, row := range outputRows {
+		reviews = append(reviews, row.Review)
+	}
+	if len(outputRows) == 0 {
+		return reviews, 0, nil
+	}
+	return reviews, outputRows[0].Count, nil
+}
+
+// Update saves rating, comment and status of the review. updated_at is set by the trigger
+func (m *ReviewModel) Update(ctx context.Context, review *models.Review) (*models.Review, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		"UPDATE reviews SET rating = $1, comment = $2, status = $3 WHERE id = $4 AND deleted_at IS NULL RETURNING *",
+		review.Rating,
+		review.Comment,
+		review.Status,
+		review.ID,
+	)
+	updatedReview, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Review])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &updatedReview, nil
+}
+
+// Delete deletes the review. If the review has replies, it's kept as a tombstone without the comment, so the thread stays readable
+func (m *ReviewModel) Delete(ctx context.Context, id int64) error {
+	var affected int
+	err := m.DB.QueryRow(
+		ctx,
+		`WITH review AS (
+			SELECT id, EXISTS (SELECT 1 FROM review_replies WHERE review_id = reviews.id) AS has_replies
+			FROM reviews WHERE id = $1 AND deleted_at IS NULL
+		), tombstoned AS (
+			UPDATE reviews SET comment = '', deleted_at = NOW() WHERE id IN (SELECT id FROM review WHERE has_replies) RETURNING id
+		), deleted AS (
+			DELETE FROM reviews WHERE id IN (SELECT id FROM review WHERE NOT has_replies) RETURNING id
+		)
+		SELECT (SELECT count(*) FROM tombstoned) + (SELECT count(*) FROM deleted)`,
+		id,
+	).Scan(&affected)
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// Flag records the user complaint about the review. Approved review stays public and is moved to flagged,
+// which hides it until moderation, only once the number of its flags reaches the threshold.
+// Only approved or already flagged reviews can be flagged, storage.ErrNotFound is returned for others.
+// Returns storage.ErrConflict if the user has already flagged the review
+func (m *ReviewModel) Flag(ctx context.Context, reviewID int64, userID int64, reason string, threshold int) (*models.ReviewFlag, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`WITH review AS (
+			SELECT id FROM reviews WHERE id = $1 AND status IN ('approved', 'flagged') AND deleted_at IS NULL
+		), flag AS (
+			INSERT INTO review_flags (review_id, user_id, reason) SELECT id, $2, $3 FROM review RETURNING *
+		), flagged AS (
+			UPDATE reviews SET status = 'flagged' WHERE id IN (SELECT review_id FROM flag) AND status = 'approved'
+			AND (SELECT count(*) FROM review_flags WHERE review_id = $1) + 1 >= $4 -- the new flag isn't visible here yet
+		)
+		SELECT * FROM flag`,
+		reviewID,
+		userID,
+		reason,
+		threshold,
+	)
+	flag, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.ReviewFlag])
+	if err != nil {
+		var pgxErr *pgconn.PgError
+		switch {
+		case errors.As(err, &pgxErr) && pgxErr.Code == postgres.ErrConflictCode:
+			return nil, storage.ErrConflict
+		case errors.Is(err, pgx.ErrNoRows):
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &flag, nil
+}
+
+// ListModerationQueue selects the page of reviews having one of the statuses, the oldest ones first.
+// Flagged status includes approved reviews with flags below the threshold, as they await moderator decision as well
+func (m *ReviewModel) ListModerationQueue(ctx context.Context, statuses []string, filters filters.Filters) ([]models.ModerationQueueItem, int, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT count(*) OVER(), r.*, (SELECT count(*) FROM review_flags f WHERE f.review_id = r.id) AS flags_count
+		FROM reviews r
+		WHERE (
+			r.status = ANY($1)
+			OR ('flagged' = ANY($1) AND r.status = 'approved' AND EXISTS (SELECT 1 FROM review_flags f WHERE f.review_id = r.id))
+		) AND r.deleted_at IS NULL
+		ORDER BY r.created_at ASC, r.id ASC
+		LIMIT $2 OFFSET $3`,
+		statuses,
+		filters.Limit(),
+		filters.Offset(),
+	)
+	type row struct {
+		Count int
+		models.ModerationQueueItem
+	}
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		return nil, 0, err
+	}
+	items := make([]models.ModerationQueueItem, 0, len(outputRows))
+	for _, row := range outputRows {
+		items = append(items, row.ModerationQueueItem)
+	}
+	if len(outputRows) == 0 {
+		return items, 0, nil
+	}
+	return items, outputRows[0].Count, nil
+}
+
+// Moderate sets the status decided by the moderator. Flags of the review are resolved by the decision, so they are deleted
+func (m *ReviewModel) Moderate(ctx context.Context, id int64, status string, reason *string, moderatorID int64) (*models.Review, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`WITH resolved AS (
+			DELETE FROM review_flags WHERE review_id = $1
+		)
+		UPDATE reviews SET status = $2, moderation_reason = $3, moderated_by = $4, moderated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL RETURNING *`,
+		id,
+		status,
+		reason,
+		moderatorID,
+	)
+	review, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.Review])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &review, nil
+}
+
+// Vote saves the user vote for the review, replacing the previous one. Counters of the review are updated by the trigger
+func (m *ReviewModel) Vote(ctx context.Context, reviewID int64, userID int64, helpful bool) error {
+	_, err := m.DB.Exec(
+		ctx,
+		`INSERT INTO review_votes (review_id, user_id, helpful) VALUES ($1, $2, $3)
+		ON CONFLICT ON CONSTRAINT review_votes_review_id_user_id_uniqueness DO UPDATE SET helpful = EXCLUDED.helpful`,
+		reviewID,
+		userID,
+		helpful,
+	)
+	return err
+}
+
+// DeleteVote retracts the user vote. Returns storage.ErrNotFound if the user hasn't voted for the review
+func (m *ReviewModel) DeleteVote(ctx context.Context, reviewID int64, userID int64) error {
+	status, err := m.DB.Exec(ctx, "DELETE FROM review_votes WHERE review_id = $1 AND user_id = $2", reviewID, userID)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// ListForUser selects the page of the user reviews with titles of the reviewed movies.
+// If approvedOnly is true, reviews which aren't public are skipped. Reviews of the movies in the trash are skipped too
+func (m *ReviewModel) ListForUser(ctx context.Context, userID int64, approvedOnly bool, filters filters.Filters) ([]models.UserReview, int, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), r.*, m.title AS movie_title FROM reviews r
+	JOIN movies m ON m.id = r.movie_id
+	WHERE r.user_id = $1 AND r.deleted_at IS NULL AND m.deleted_at IS NULL AND ($2 = false OR r.status = 'approved')
+	ORDER BY r.%s %s, r.id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.SortColumn(), filters.SortDirection())
+	rows, _ := m.DB.Query(ctx, query, userID, approvedOnly, filters.Limit(), filters.Offset())
+	type row struct {
+		Count int
+		models.UserReview
+	}
+	outputRows, err := pgx.CollectRows(rows, pgx.RowToStructByName[row])
+	if err != nil {
+		return nil, 0, err
+	}
+	reviews := make([]models.UserReview, 0, len(outputRows))
+	for _, row := range outputRows {
+		reviews = append(reviews, row.UserReview)
+	}
+	if len(outputRows) == 0 {
+		return reviews, 0, nil
+	}
+	return reviews, outputRows[0].Count, nil
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"greenlight/proj/internal/domain/models"
+	"greenlight/proj/internal/storage"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type TokenModel struct {
+	DB *pgxpool.Pool
+}
+
+// CreateRefreshTokenFamily starts the family of refresh tokens for the login with its first token
+func (m *TokenModel) CreateRefreshTokenFamily(ctx context.Context, userID int64, sealedSsoRefreshToken []byte, hash []byte, expiresAt time.Time) error {
+	_, err := m.DB.Exec(
+		ctx,
+		`WITH family AS (
+			INSERT INTO refresh_token_families (user_id, sso_refresh_token, expires_at) VALUES ($1, $2, $3) RETURNING id
+		)
+		INSERT INTO refresh_tokens (hash, family_id) SELECT $4, id FROM family`,
+		userID,
+		sealedSsoRefreshToken,
+		expiresAt,
+		hash,
+	)
+	return err
+}
+
+// GetRefreshToken selects the refresh token by its hash alongside with the state of its family
+func (m *TokenModel) GetRefreshToken(ctx context.Context, hash []byte) (*models.RefreshToken, error) {
+	rows, _ := m.DB.Query(
+		ctx,
+		`SELECT t.hash, t.family_id, f.user_id, f.sso_refresh_token, f.expires_at, t.rotated_at, f.revoked_at
+		FROM refresh_tokens t
+		JOIN refresh_token_families f ON f.id = t.family_id
+		WHERE t.hash = $1`,
+		hash,
+	)
+	token, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[models.RefreshToken])
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RotateRefreshToken marks the token as rotated and adds the new one to its family.
+// Returns storage.ErrConflict if the token has been rotated already, e.g. by the concurrent request
+func (m *TokenModel) RotateRefreshToken(ctx context.Context, hash []byte, newHash []byte) error {
+	status, err := m.DB.Exec(
+		ctx,
+		`WITH rotated AS (
+			UPDATE refresh_tokens SET rotated_at = NOW() WHERE hash = $1 AND rotated_at IS NULL RETURNING family_id
+		)
+		INSERT INTO refresh_tokens (hash, family_id) SELECT $2, family_id FROM rotated`,
+		hash,
+		newHash,
+	)
+	if err != nil {
+		return err
+	}
+	if status.RowsAffected() == 0 {
+		return storage.ErrConflict
+	}
+	return nil
+}
+
+// RevokeRefreshTokenFamily revokes all the tokens issued since the same login
+func (m *TokenModel) RevokeRefreshTokenFamily(ctx context.Context, familyID int64) error {
+	_, err := m.DB.Exec(ctx, "UPDATE refresh_token_families SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL", familyID)
+	return err
+}
+
+// RevokeAccessToken adds the access token to the revocation list until it expires
+func (m *TokenModel) RevokeAccessToken(ctx context.Context, tokenID string, userID int64, expiresAt time.Time) error {
+	_, err := m.DB.Exec(
+		ctx,
+		`INSERT INTO access_token_revocations (token_id, user_id, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (token_id) DO NOTHING`,
+		tokenID,
+		userID,
+		expiresAt,
+	)
+	return err
+}
+
+// RevokeUserTokens revokes all the refresh token families and API keys of the user and rejects the user access tokens
+// issued before revokedBefore. The revocation is kept until expiresAt, when such access tokens have expired anyway
+func (m *TokenModel) RevokeUserTokens(ctx context.Context, userID int64, revokedBefore time.Time, expiresAt time.Time) error {
+	_, err := m.DB.Exec(
+		ctx,
+		`WITH families AS (
+			UPDATE refresh_token_families SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
+		), api_keys AS (
+			UPDATE api_keys SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL
+		)
+		INSERT INTO user_token_revocations (user_id, revoked_before, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			revoked_before = GREATEST(user_token_revocations.revoked_before, EXCLUDED.revoked_before),
+			expires_at = GREATEST(user_token_revocations.expires_at, EXCLUDED.expires_at)`,
+		userID,
+		revokedBefore,
+		expiresAt,
+	)
+	return err
+}
+
+// IsAccessTokenRevoked checks whether the token itself is revoked, or all the user tokens issued before issuedAt are
+func (m *TokenModel) IsAccessTokenRevoked(ctx context.Context, tokenID string, userID int64, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := m.DB.QueryRow(
+		ctx,
+		`SELECT EXISTS (
+			SELECT 1 FROM access_token_revocations WHERE token_id = $1 AND expires_at > NOW()
+		) OR EXISTS (
+			SELECT 1 FROM user_token_revocations WHERE user_id = $2 AND revoked_before >= $3 AND expires_at > NOW()
+		)`,
+		tokenID,
+		userID,
+		issuedAt,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+// PurgeExpiredRevocations deletes revocations of the tokens which have expired anyway. Returns the number of deleted revocations
+func (m *TokenModel) PurgeExpiredRevocations(ctx context.Context) (int64, error) {
+	var purged int64
+	err := m.DB.QueryRow(
+		ctx,
+		`WITH tokens AS (
+			DELETE FROM access_token_revocations WHERE expires_at <= NOW() RETURNING 1
+		), users AS (
+			DELETE FROM user_token_revocations WHERE expires_at <= NOW() RETURNING 1
+		)
+		SELECT (SELECT count(*) FROM tokens) + (SELECT count(*) FROM users)`,
+	).Scan(&purged)
+	return purged, err
+}
+
+// PurgeExpiredRefreshTokens deletes expired and revoked refresh token families, their tokens are deleted by cascade
+func (m *TokenModel) PurgeExpiredRefreshTokens(ctx context.Context) (int64, error) {
+	status, err := m.DB.Exec(ctx, "DELETE FROM refresh_token_families WHERE expires_at <= NOW() OR revoked_at IS NOT NULL")
+	if err != nil {
+		return 0, err
+	}
+	return status.RowsAffected(), nil
+}
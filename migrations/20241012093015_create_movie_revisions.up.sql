@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    movie_id INT NOT NULL REFERENCES movies (id) ON DELETE CASCADE,
+    version INT NOT NULL,
+    user_id INT NOT NULL CHECK (user_id > 0),
+    changes JSONB NOT NULL DEFAULT '{}',
+    snapshot JSONB NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE movie_revisions ADD CONSTRAINT movie_revisions_movie_id_version_uniqueness UNIQUE (movie_id, version);
+
+-- Current state of already existing movies becomes their first known revision
+INSERT INTO movie_revisions (movie_id, version, user_id, snapshot)
+SELECT id, version, user_id, jsonb_build_object(
+    'title', title, 'year', year, 'runtime', runtime || ' mins', 'genres', genres
+) FROM movies
+ON CONFLICT DO NOTHING;
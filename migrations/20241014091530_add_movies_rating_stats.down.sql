@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS reviews_rating_stats ON reviews;
+DROP FUNCTION IF EXISTS reviews_rating_stats_t;
+DROP FUNCTION IF EXISTS adjust_movie_rating_stats;
+
+DROP INDEX IF EXISTS movies_reviews_count_idx;
+DROP INDEX IF EXISTS movies_average_rating_idx;
+
+ALTER TABLE movies
+    DROP COLUMN IF EXISTS rating_histogram,
+    DROP COLUMN IF EXISTS reviews_count,
+    DROP COLUMN IF EXISTS average_rating;
@@ -0,0 +1,59 @@
+ALTER TABLE movies
+    ADD COLUMN IF NOT EXISTS average_rating numeric(3, 2) NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS reviews_count INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS rating_histogram INT[] NOT NULL DEFAULT '{0,0,0,0,0}';
+
+-- Stats are adjusted incrementally. UPDATE locks the movie row, so concurrent reviews of the same movie
+-- are applied one after another and none of them is lost
+CREATE OR REPLACE FUNCTION adjust_movie_rating_stats(p_movie_id INT, p_rating INT, p_delta INT) RETURNS VOID AS $$
+BEGIN
+    UPDATE movies SET
+        rating_histogram[p_rating] = rating_histogram[p_rating] + p_delta,
+        reviews_count = reviews_count + p_delta,
+        average_rating = COALESCE(
+            ((SELECT sum(h.n * h.rating) FROM unnest(rating_histogram) WITH ORDINALITY AS h(n, rating)) + p_delta * p_rating)::numeric
+            / NULLIF(reviews_count + p_delta, 0),
+            0
+        )
+    WHERE id = p_movie_id;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION reviews_rating_stats_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM adjust_movie_rating_stats(OLD.movie_id, OLD.rating, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM adjust_movie_rating_stats(NEW.movie_id, NEW.rating, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER reviews_rating_stats
+AFTER INSERT OR DELETE OR UPDATE OF rating, movie_id ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_rating_stats_t();
+
+UPDATE movies SET
+    reviews_count = stats.reviews_count,
+    average_rating = stats.average_rating,
+    rating_histogram = stats.rating_histogram
+FROM (
+    SELECT
+        movie_id,
+        count(*) AS reviews_count,
+        avg(rating) AS average_rating,
+        ARRAY[
+            count(*) FILTER (WHERE rating = 1),
+            count(*) FILTER (WHERE rating = 2),
+            count(*) FILTER (WHERE rating = 3),
+            count(*) FILTER (WHERE rating = 4),
+            count(*) FILTER (WHERE rating = 5)
+        ]::INT[] AS rating_histogram
+    FROM reviews GROUP BY movie_id
+) AS stats
+WHERE movies.id = stats.movie_id;
+
+CREATE INDEX IF NOT EXISTS movies_average_rating_idx ON movies (average_rating, id);
+CREATE INDEX IF NOT EXISTS movies_reviews_count_idx ON movies (reviews_count, id);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS reviews_update_timestamp ON reviews;
+
+CREATE OR REPLACE TRIGGER reviews_update_timestamp
+AFTER UPDATE ON reviews
+FOR EACH ROW EXECUTE FUNCTION update_timestamp_t();
@@ -0,0 +1,6 @@
+-- AFTER UPDATE trigger can't modify the row being updated, so updated_at was never changed
+DROP TRIGGER IF EXISTS reviews_update_timestamp ON reviews;
+
+CREATE OR REPLACE TRIGGER reviews_update_timestamp
+BEFORE UPDATE ON reviews
+FOR EACH ROW EXECUTE FUNCTION update_timestamp_t();
@@ -0,0 +1,46 @@
+CREATE OR REPLACE FUNCTION reviews_rating_stats_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        PERFORM adjust_movie_rating_stats(OLD.movie_id, OLD.rating, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        PERFORM adjust_movie_rating_stats(NEW.movie_id, NEW.rating, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER reviews_rating_stats
+AFTER INSERT OR DELETE OR UPDATE OF rating, movie_id ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_rating_stats_t();
+
+DROP TABLE IF EXISTS review_flags;
+
+DROP INDEX IF EXISTS reviews_moderation_queue_idx;
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS moderated_at,
+    DROP COLUMN IF EXISTS moderated_by,
+    DROP COLUMN IF EXISTS moderation_reason,
+    DROP COLUMN IF EXISTS status;
+
+-- Stats have to count not approved reviews again
+UPDATE movies SET
+    reviews_count = COALESCE(stats.reviews_count, 0),
+    average_rating = COALESCE(stats.average_rating, 0),
+    rating_histogram = COALESCE(stats.rating_histogram, '{0,0,0,0,0}')
+FROM movies AS m LEFT JOIN (
+    SELECT
+        movie_id,
+        count(*) AS reviews_count,
+        avg(rating) AS average_rating,
+        ARRAY[
+            count(*) FILTER (WHERE rating = 1),
+            count(*) FILTER (WHERE rating = 2),
+            count(*) FILTER (WHERE rating = 3),
+            count(*) FILTER (WHERE rating = 4),
+            count(*) FILTER (WHERE rating = 5)
+        ]::INT[] AS rating_histogram
+    FROM reviews GROUP BY movie_id
+) AS stats ON stats.movie_id = m.id
+WHERE movies.id = m.id;
@@ -0,0 +1,37 @@
+-- Existing reviews have been published already, so they are approved, while new ones wait for moderation
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'approved'
+        CHECK (status IN ('pending', 'approved', 'rejected', 'flagged')),
+    ADD COLUMN IF NOT EXISTS moderation_reason TEXT,
+    ADD COLUMN IF NOT EXISTS moderated_by INT,
+    ADD COLUMN IF NOT EXISTS moderated_at timestamp(0) with time zone;
+
+ALTER TABLE reviews ALTER COLUMN status SET DEFAULT 'pending';
+
+CREATE INDEX IF NOT EXISTS reviews_moderation_queue_idx ON reviews (created_at, id) WHERE status IN ('pending', 'flagged');
+
+CREATE TABLE IF NOT EXISTS review_flags (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    review_id INT NOT NULL REFERENCES reviews (id) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    reason TEXT NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT review_flags_review_id_user_id_uniqueness UNIQUE (review_id, user_id)
+);
+
+-- Only approved reviews are counted in the movie rating stats
+CREATE OR REPLACE FUNCTION reviews_rating_stats_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.status = 'approved' THEN
+        PERFORM adjust_movie_rating_stats(OLD.movie_id, OLD.rating, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.status = 'approved' THEN
+        PERFORM adjust_movie_rating_stats(NEW.movie_id, NEW.rating, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER reviews_rating_stats
+AFTER INSERT OR DELETE OR UPDATE OF rating, movie_id, status ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_rating_stats_t();
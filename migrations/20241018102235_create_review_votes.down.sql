@@ -0,0 +1,17 @@
+DROP TRIGGER IF EXISTS review_votes_counters ON review_votes;
+DROP FUNCTION IF EXISTS review_votes_counters_t;
+
+DROP TRIGGER IF EXISTS reviews_update_timestamp ON reviews;
+
+CREATE OR REPLACE TRIGGER reviews_update_timestamp
+BEFORE UPDATE ON reviews
+FOR EACH ROW EXECUTE FUNCTION update_timestamp_t();
+
+DROP INDEX IF EXISTS reviews_movie_id_helpful_score_idx;
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS helpful_score,
+    DROP COLUMN IF EXISTS unhelpful_votes,
+    DROP COLUMN IF EXISTS helpful_votes;
+
+DROP TABLE IF EXISTS review_votes;
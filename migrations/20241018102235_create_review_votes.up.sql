@@ -0,0 +1,45 @@
+CREATE TABLE IF NOT EXISTS review_votes (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    review_id INT NOT NULL REFERENCES reviews (id) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    helpful BOOLEAN NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT review_votes_review_id_user_id_uniqueness UNIQUE (review_id, user_id)
+);
+
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS helpful_votes INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS unhelpful_votes INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS helpful_score INT GENERATED ALWAYS AS (helpful_votes - unhelpful_votes) STORED;
+
+CREATE INDEX IF NOT EXISTS reviews_movie_id_helpful_score_idx ON reviews (movie_id, helpful_score DESC, id);
+
+-- Votes counters are kept on reviews, so updated_at has to be changed by the content updates only
+DROP TRIGGER IF EXISTS reviews_update_timestamp ON reviews;
+
+CREATE OR REPLACE TRIGGER reviews_update_timestamp
+BEFORE UPDATE OF rating, comment ON reviews
+FOR EACH ROW EXECUTE FUNCTION update_timestamp_t();
+
+-- Counters are adjusted incrementally under the review row lock, the same way as the movie rating stats
+CREATE OR REPLACE FUNCTION review_votes_counters_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE reviews SET
+            helpful_votes = helpful_votes - OLD.helpful::INT,
+            unhelpful_votes = unhelpful_votes - (NOT OLD.helpful)::INT
+        WHERE id = OLD.review_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE reviews SET
+            helpful_votes = helpful_votes + NEW.helpful::INT,
+            unhelpful_votes = unhelpful_votes + (NOT NEW.helpful)::INT
+        WHERE id = NEW.review_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER review_votes_counters
+AFTER INSERT OR DELETE OR UPDATE OF helpful ON review_votes
+FOR EACH ROW EXECUTE FUNCTION review_votes_counters_t();
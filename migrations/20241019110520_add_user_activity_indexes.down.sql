@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_user_id_idx;
+DROP INDEX IF EXISTS reviews_user_id_idx;
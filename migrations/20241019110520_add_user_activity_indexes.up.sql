@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+CREATE INDEX IF NOT EXISTS movies_user_id_idx ON movies (user_id);
@@ -0,0 +1,29 @@
+DROP TABLE IF EXISTS review_replies;
+
+DROP FUNCTION IF EXISTS review_replies_counters_t;
+
+-- Tombstones have no content, so they are deleted along with the threads
+DELETE FROM reviews WHERE deleted_at IS NOT NULL;
+
+CREATE OR REPLACE FUNCTION reviews_rating_stats_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.status = 'approved' THEN
+        PERFORM adjust_movie_rating_stats(OLD.movie_id, OLD.rating, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.status = 'approved' THEN
+        PERFORM adjust_movie_rating_stats(NEW.movie_id, NEW.rating, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER reviews_rating_stats
+AFTER INSERT OR DELETE OR UPDATE OF rating, movie_id, status ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_rating_stats_t();
+
+DROP INDEX IF EXISTS reviews_movie_id_user_id_uniqueness;
+ALTER TABLE reviews ADD CONSTRAINT reviews_movie_id_user_id_uniqueness UNIQUE (movie_id, user_id);
+
+ALTER TABLE reviews
+    DROP COLUMN IF EXISTS replies_count,
+    DROP COLUMN IF EXISTS deleted_at;
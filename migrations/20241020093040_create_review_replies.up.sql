@@ -0,0 +1,71 @@
+-- Deleted reviews with replies are kept as tombstones, so the threads stay readable
+ALTER TABLE reviews
+    ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone,
+    ADD COLUMN IF NOT EXISTS replies_count INT NOT NULL DEFAULT 0;
+
+-- The user can review the movie again after the review is deleted
+ALTER TABLE reviews DROP CONSTRAINT IF EXISTS reviews_movie_id_user_id_uniqueness;
+CREATE UNIQUE INDEX IF NOT EXISTS reviews_movie_id_user_id_uniqueness ON reviews (movie_id, user_id) WHERE deleted_at IS NULL;
+
+-- Tombstones aren't counted in the movie rating stats
+CREATE OR REPLACE FUNCTION reviews_rating_stats_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') AND OLD.status = 'approved' AND OLD.deleted_at IS NULL THEN
+        PERFORM adjust_movie_rating_stats(OLD.movie_id, OLD.rating, -1);
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') AND NEW.status = 'approved' AND NEW.deleted_at IS NULL THEN
+        PERFORM adjust_movie_rating_stats(NEW.movie_id, NEW.rating, 1);
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER reviews_rating_stats
+AFTER INSERT OR DELETE OR UPDATE OF rating, movie_id, status, deleted_at ON reviews
+FOR EACH ROW EXECUTE FUNCTION reviews_rating_stats_t();
+
+CREATE TABLE IF NOT EXISTS review_replies (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    review_id INT NOT NULL REFERENCES reviews (id) ON DELETE CASCADE,
+    parent_id INT REFERENCES review_replies (id) ON DELETE CASCADE,
+    user_id INT NOT NULL,
+    body TEXT NOT NULL,
+    depth INT NOT NULL DEFAULT 0 CHECK (depth >= 0),
+    replies_count INT NOT NULL DEFAULT 0,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    deleted_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS review_replies_thread_idx ON review_replies (review_id, parent_id, created_at, id);
+
+CREATE OR REPLACE TRIGGER review_replies_update_timestamp
+BEFORE UPDATE OF body ON review_replies
+FOR EACH ROW EXECUTE FUNCTION update_timestamp_t();
+
+-- Reviews count replies which aren't deleted, while replies count all their direct children,
+-- because tombstones are listed in the thread too
+CREATE OR REPLACE FUNCTION review_replies_counters_t() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE reviews SET replies_count = replies_count + 1 WHERE id = NEW.review_id;
+        IF NEW.parent_id IS NOT NULL THEN
+            UPDATE review_replies SET replies_count = replies_count + 1 WHERE id = NEW.parent_id;
+        END IF;
+    ELSIF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NULL THEN
+            UPDATE reviews SET replies_count = replies_count - 1 WHERE id = OLD.review_id;
+        END IF;
+        IF OLD.parent_id IS NOT NULL THEN
+            UPDATE review_replies SET replies_count = replies_count - 1 WHERE id = OLD.parent_id;
+        END IF;
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        UPDATE reviews SET replies_count = replies_count - 1 WHERE id = NEW.review_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER review_replies_counters
+AFTER INSERT OR DELETE OR UPDATE OF deleted_at ON review_replies
+FOR EACH ROW EXECUTE FUNCTION review_replies_counters_t();
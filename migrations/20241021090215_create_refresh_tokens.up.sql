@@ -0,0 +1,22 @@
+-- Every login starts a family of refresh tokens. Clients get opaque tokens issued by the app,
+-- while the SSO refresh token of the login stays on the server encrypted with tokens.encryption_key
+CREATE TABLE IF NOT EXISTS refresh_token_families (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL,
+    sso_refresh_token BYTEA NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone NOT NULL,
+    revoked_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_families_user_id_idx ON refresh_token_families (user_id);
+
+-- Only hashes of the tokens are stored. Rotated tokens are kept to detect their reuse
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    hash BYTEA PRIMARY KEY,
+    family_id INT NOT NULL REFERENCES refresh_token_families (id) ON DELETE CASCADE,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    rotated_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
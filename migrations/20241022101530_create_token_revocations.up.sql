@@ -0,0 +1,16 @@
+-- Revoked access tokens are kept until they would have expired anyway
+CREATE TABLE IF NOT EXISTS access_token_revocations (
+    token_id TEXT PRIMARY KEY,
+    user_id INT NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL
+);
+
+-- Access tokens of the user issued before revoked_before are rejected, e.g. after all sessions are revoked
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+    user_id INT PRIMARY KEY,
+    revoked_before timestamp(0) with time zone NOT NULL,
+    expires_at timestamp(0) with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS access_token_revocations_expires_at_idx ON access_token_revocations (expires_at);
+CREATE INDEX IF NOT EXISTS user_token_revocations_expires_at_idx ON user_token_revocations (expires_at);
@@ -0,0 +1,15 @@
+-- Only hashes of the keys are stored, the prefix is kept to tell the keys apart in the listing
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+    user_id INT NOT NULL,
+    name TEXT NOT NULL,
+    prefix TEXT NOT NULL,
+    hash BYTEA NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    expires_at timestamp(0) with time zone,
+    last_used_at timestamp(0) with time zone,
+    revoked_at timestamp(0) with time zone
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_user_id_name_idx ON api_keys (user_id, name) WHERE revoked_at IS NULL;
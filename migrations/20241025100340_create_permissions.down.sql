@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS permission_revocations;
+DROP TABLE IF EXISTS permissions;
@@ -0,0 +1,22 @@
+-- SSO can't list or revoke permissions, so the app keeps the registry of the codes it knows about
+-- and revocations which override the grants stored in SSO
+CREATE TABLE IF NOT EXISTS permissions (
+    code TEXT PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO permissions (code) VALUES
+    ('movies:read'),
+    ('movies:write'),
+    ('movies:admin'),
+    ('reviews:moderate'),
+    ('apikeys:manage')
+ON CONFLICT DO NOTHING;
+
+CREATE TABLE IF NOT EXISTS permission_revocations (
+    user_id INT NOT NULL,
+    code TEXT NOT NULL REFERENCES permissions ON DELETE CASCADE,
+    revoked_by INT NOT NULL,
+    revoked_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code)
+);
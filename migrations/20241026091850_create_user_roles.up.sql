@@ -0,0 +1,15 @@
+-- Roles are defined in the config, SSO can't store them, so the role of the user is kept by the app.
+-- role_definitions is the snapshot of the definitions the users have been synced with
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT PRIMARY KEY,
+    role TEXT NOT NULL,
+    assigned_by INT NOT NULL,
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_roles_role_idx ON user_roles (role);
+
+CREATE TABLE IF NOT EXISTS role_definitions (
+    role TEXT PRIMARY KEY,
+    permissions TEXT[] NOT NULL
+);
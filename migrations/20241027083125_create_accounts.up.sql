@@ -0,0 +1,5 @@
+-- SSO can't delete users, so closed accounts are recorded by the app and can't be authenticated
+CREATE TABLE IF NOT EXISTS closed_accounts (
+    user_id INT PRIMARY KEY,
+    closed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS movies_unique_idx;
+
+ALTER TABLE movies ADD CONSTRAINT movies_unique_check UNIQUE (title, version, year);
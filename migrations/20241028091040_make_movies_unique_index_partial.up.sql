@@ -0,0 +1,4 @@
+-- Movies in the trash don't block creation of the same movie. Restoring such movie fails with the conflict
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_unique_check;
+
+CREATE UNIQUE INDEX IF NOT EXISTS movies_unique_idx ON movies (title, version, year) WHERE deleted_at IS NULL;